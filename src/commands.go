@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"golang.org/x/term"
 	"os"
+	"strings"
 	"syscall"
 
 	"IB1/config"
 	"IB1/db"
+	"IB1/media"
+	"IB1/storage"
 )
 
 func askPassword() (string, error) {
@@ -164,7 +167,9 @@ func parseArguments() error {
 			return err
 		}
 	case "media":
-		err := errors.New(os.Args[0] + " extract|load <path>")
+		err := errors.New(os.Args[0] +
+			" extract|load <path> | thumbnails regenerate | " +
+			"migrate <database|filesystem|s3> [path]")
 		if len(os.Args) < 4 {
 			return err
 		}
@@ -180,6 +185,91 @@ func parseArguments() error {
 			if err := db.Load(os.Args[3]); err != nil {
 				return err
 			}
+		case "migrate":
+			target := storage.Configured()
+			target.Backend = os.Args[3]
+			if len(os.Args) > 4 {
+				target.Path = os.Args[4]
+			}
+			err := db.Migrate(target, func(m db.Migration) {
+				fmt.Printf("\r%d/%d media moved", m.Moved, m.Total)
+			})
+			fmt.Println("")
+			if err != nil {
+				return err
+			}
+			fmt.Println("media moved to the", target.Backend, "storage")
+			if m := db.GetMigration(); m.Warning != "" {
+				fmt.Println(m.Warning)
+			}
+		case "thumbnails":
+			if os.Args[3] != "regenerate" {
+				return err
+			}
+			count, err := media.RegenerateThumbnails()
+			if err != nil {
+				return err
+			}
+			fmt.Println(count, "thumbnails regenerated")
+		default:
+			return err
+		}
+	case "bans":
+		err := errors.New(os.Args[0] +
+			" bans import|export <path> [source] | remove <source>")
+		if len(os.Args) < 4 {
+			return err
+		}
+		if err := db.Init(); err != nil {
+			return err
+		}
+		source := ""
+		if len(os.Args) > 4 {
+			source = os.Args[4]
+		}
+		switch os.Args[2] {
+		case "import":
+			f, err := os.Open(os.Args[3])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			list, err := db.ParseHashList(f)
+			if err != nil {
+				return err
+			}
+			imported, duplicates, err := db.ImportBannedImages(list,
+				source)
+			if err != nil {
+				return err
+			}
+			fmt.Println(len(imported), "bans imported,", duplicates,
+				"duplicates skipped")
+			matches, err := media.CountMatches(imported)
+			if err != nil {
+				return err
+			}
+			fmt.Println(matches, "existing media match the new hashes")
+		case "export":
+			format := "csv"
+			if strings.HasSuffix(os.Args[3], ".json") {
+				format = "json"
+			}
+			f, err := os.Create(os.Args[3])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			err = db.ExportBannedImages(f, format, source)
+			if err != nil {
+				return err
+			}
+			fmt.Println("banned hashes exported")
+		case "remove":
+			if err := db.RemoveBannedSource(os.Args[3]); err != nil {
+				return err
+			}
+			fmt.Println("banned hashes of", os.Args[3], "removed")
 		default:
 			return err
 		}
@@ -188,6 +278,12 @@ func parseArguments() error {
 			" register <name> <trusted|moderator|admin>")
 		fmt.Println(os.Args[0] + " media extract <path>")
 		fmt.Println(os.Args[0] + " media load <path>")
+		fmt.Println(os.Args[0] + " media thumbnails regenerate")
+		fmt.Println(os.Args[0] +
+			" media migrate <database|filesystem|s3> [path]")
+		fmt.Println(os.Args[0] + " bans import <path> [source]")
+		fmt.Println(os.Args[0] + " bans export <path> [source]")
+		fmt.Println(os.Args[0] + " bans remove <source>")
 		fmt.Println(os.Args[0] + " passwd <name>")
 		fmt.Println(os.Args[0] + " domain <domain>")
 		fmt.Println(os.Args[0] + " db <path> [sqlite|sqlite3|mysql]")
@@ -12,6 +12,15 @@ type RateLimit struct {
 	Timeout     int
 }
 
+// S3 is the location and credentials of an S3-compatible bucket
+type S3 struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
 type Config struct {
 	Home struct {
 		Title       string
@@ -40,25 +49,69 @@ type Config struct {
 		RedirectToSSL bool
 	}
 	Media struct {
-		InDatabase      bool
-		Path            string
-		Tmp             string
-		MaxSize         uint64
-		ApprovalQueue   bool
-		AllowVideos     bool
-		Key             []byte
-		PendingMedia    []byte
-		PendingMime     string
+		InDatabase          bool
+		Storage             string
+		Path                string
+		S3                  S3
+		Tmp                 string
+		MaxSize             uint64
+		MaxDuration         int
+		Workers             int
+		QueueSize           int
+		ApprovalQueue       bool
+		AllowVideos         bool
+		AllowAudio          bool
+		Key                 []byte
+		PendingMedia        []byte
+		PendingMime         string
+		Spoiler             []byte
+		SpoilerMime         string
+		ImageThreshold      int
+		DifferenceThreshold int
+		PerceptionThreshold int
+		MatchAllHashes      bool
+		FrameInterval       int
+		MaxFrames           int
+		ThumbnailSize       int
+		ReplyThumbnailSize  int
+		ThumbnailFormat     string
+		ThumbnailQuality    int
+		AnimatedThumbnails  bool
+		AnimatedDuration    int
+		KeepFilename        bool
+		SignedURLs          bool
+		URLLifetime         int
+		URLSession          bool
+		URLKey              []byte
+		AllowedReferrers    []string
+		ConsumerKeys        []string
+		// HotlinkShield was replaced by SignedURLs and is only read to
+		// migrate older configurations
+		HotlinkShield int
+		// NotificationURL was replaced by the notification channels and
+		// is only read to migrate older configurations
 		NotificationURL string
-		Spoiler         []byte
-		SpoilerMime     string
-		ImageThreshold  int
-		HotlinkShield   int
-		HotlinkKey      []byte
 	}
 	Captcha struct {
-		Enabled bool
-		Length  int
+		Enabled       bool
+		Provider      string
+		Length        int
+		AudioLanguage string
+		Login         bool
+		Registration  bool
+		Thread        bool
+		Post          bool
+		External      struct {
+			VerifyURL string
+			Secret    string
+			SiteKey   string
+			Script    string
+			Class     string
+			// Origins are allowed by the Content-Security-Policy besides
+			// the one of the script, the widget of some providers
+			// loading frames from other hosts
+			Origins string
+		}
 	}
 	Post struct {
 		DefaultName string
@@ -74,6 +127,32 @@ type Config struct {
 		DefaultRank       string
 		MinimumEntropy    float64
 	}
+	Spam struct {
+		Enabled         bool
+		Hide            float64
+		Queue           float64
+		Reject          float64
+		MaxLinks        int
+		LinkScore       float64
+		RepeatScore     float64
+		CapsRatio       float64
+		CapsScore       float64
+		BadDomains      []string
+		DomainScore     float64
+		NewSessionScore float64
+		BayesScore      float64
+	}
+	Flood struct {
+		Window    int
+		SiteWide  bool
+		MinLength int
+	}
+	Notify struct {
+		Retries       int
+		LoginFailures int
+		LoginWindow   int
+		ExpiryDays    int
+	}
 	RateLimit struct {
 		Login        RateLimit
 		Registration RateLimit
@@ -93,15 +172,50 @@ func LoadDefault() {
 	Cfg.SSL.Listener = ":8443"
 	Cfg.Captcha.Enabled = true
 	Cfg.Captcha.Length = 7
+	Cfg.Captcha.AudioLanguage = "en"
+	Cfg.Captcha.Provider = "image"
+	Cfg.Captcha.Login = true
+	Cfg.Captcha.Registration = true
+	Cfg.Captcha.Thread = true
+	Cfg.Captcha.Post = true
 	Cfg.Board.MaxThreads = 40
 	Cfg.Media.MaxSize = 1024 * 1024 * 4
 	Cfg.Media.InDatabase = true
 	Cfg.Media.Path = "./media"
 	Cfg.Media.Tmp = "/tmp/ib1"
+	Cfg.Media.Workers = 2
+	Cfg.Media.QueueSize = 16
 	Cfg.Media.ImageThreshold = 16
+	Cfg.Media.DifferenceThreshold = 10
+	Cfg.Media.PerceptionThreshold = 10
+	Cfg.Media.FrameInterval = 5
+	Cfg.Media.MaxFrames = 10
+	Cfg.Media.ThumbnailSize = 200
+	Cfg.Media.ReplyThumbnailSize = 200
+	Cfg.Media.ThumbnailFormat = "png"
+	Cfg.Media.ThumbnailQuality = 85
+	Cfg.Media.AnimatedDuration = 10
+	Cfg.Media.URLLifetime = 60
 	Cfg.Post.DefaultName = "Anonymous"
 	Cfg.Post.AsciiOnly = false
 	Cfg.Board.MaxThreads = 40
+	Cfg.Spam.Hide = 4
+	Cfg.Spam.Queue = 6
+	Cfg.Spam.Reject = 10
+	Cfg.Spam.MaxLinks = 2
+	Cfg.Spam.LinkScore = 1.5
+	Cfg.Spam.RepeatScore = 4
+	Cfg.Spam.CapsRatio = 0.7
+	Cfg.Spam.CapsScore = 2
+	Cfg.Spam.DomainScore = 10
+	Cfg.Spam.NewSessionScore = 1
+	Cfg.Spam.BayesScore = 6
+	Cfg.Flood.Window = 120
+	Cfg.Flood.MinLength = 10
+	Cfg.Notify.Retries = 4
+	Cfg.Notify.LoginFailures = 20
+	Cfg.Notify.LoginWindow = 10
+	Cfg.Notify.ExpiryDays = 14
 	Cfg.RateLimit.Login.MaxAttempts = 5
 	Cfg.RateLimit.Login.Timeout = 30
 	Cfg.RateLimit.Registration.MaxAttempts = 5
@@ -130,13 +244,18 @@ func LoadConfig(data []byte) error {
 			return err
 		}
 	}
-	if Cfg.Media.HotlinkKey == nil {
-		Cfg.Media.HotlinkKey = make([]byte, 32)
-		_, err := rand.Read(Cfg.Media.HotlinkKey)
+	if Cfg.Media.URLKey == nil {
+		Cfg.Media.URLKey = make([]byte, 32)
+		_, err := rand.Read(Cfg.Media.URLKey)
 		if err != nil {
 			return err
 		}
 	}
+	if Cfg.Media.HotlinkShield != 0 {
+		Cfg.Media.SignedURLs = true
+		Cfg.Media.URLSession = Cfg.Media.HotlinkShield == 3
+		Cfg.Media.HotlinkShield = 0
+	}
 	return err
 }
 
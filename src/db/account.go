@@ -228,6 +228,68 @@ func (account Account) CanAsMember(board Board,
 	return nil
 }
 
+// ApprovalBoards returns the boards whose pending media an account can
+// approve, nil meaning every board
+func (account Account) ApprovalBoards() ([]uint, error) {
+	if account.Can(APPROVE_MEDIA) == nil {
+		return nil, nil
+	}
+	boards := []uint{}
+	var owned []Board
+	err := db.Where("owner_id = ?", account.ID).Find(&owned).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range owned {
+		boards = append(boards, v.ID)
+	}
+	var memberships []Membership
+	err = db.Preload("Rank").Where("member_id = ?", account.ID).
+		Find(&memberships).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range memberships {
+		if v.Rank.Can(APPROVE_MEDIA.Member()) {
+			boards = append(boards, uint(v.BoardID))
+		}
+	}
+	if len(boards) == 0 {
+		return nil, errNeedPrivilege
+	}
+	return boards, nil
+}
+
+// CanApprove checks if an account can approve a media, as a global
+// approver or as an approver of the boards where it was posted, the
+// media being shared by every post with the same file
+func (account Account) CanApprove(hash string) error {
+	boards, err := account.ApprovalBoards()
+	if err != nil || boards == nil {
+		return err
+	}
+	var count int64
+	err = db.Model(&Post{}).
+		Where("media_hash = ? AND board_id IN ?", hash, boards).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errNeedPrivilege
+	}
+	err = db.Model(&Post{}).
+		Where("media_hash = ? AND board_id NOT IN ?", hash, boards).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("the media is also posted on other boards")
+	}
+	return nil
+}
+
 func (account *Account) SetTheme(name string) error {
 	return db.Model(account).Updates(Account{Theme: name}).Error
 }
@@ -3,6 +3,7 @@ package db
 import (
 	"errors"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"html/template"
 
 	"IB1/config"
@@ -34,6 +35,8 @@ type Board struct {
 	Private     bool
 	CountryFlag bool
 	PosterID    bool
+	Captcha     string
+	DropAudio   bool
 	OwnerID     *uint
 	Owner       Account
 }
@@ -134,7 +137,13 @@ func (thread *Thread) Pin() error {
 }
 
 func RefreshThread(thread *Thread) error {
-	return db.Model(*thread).Preload("Posts").Find(thread).Error
+	return db.Model(*thread).Preload("Posts", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "number <> ?, timestamp, number",
+			Vars:               []interface{}{thread.Number},
+			WithoutParentheses: true,
+		}})
+	}).Find(thread).Error
 }
 
 func CreateBoard(name string, longName string,
@@ -158,6 +167,12 @@ func CreateBoard(name string, longName string,
 }
 
 func DeleteThreads(board Board) error {
+	return deleteThreads(board, 0)
+}
+
+// deleteThreads removes the threads of a board beyond the maximum, the
+// thread keep counting toward it without ever being removed
+func deleteThreads(board Board, keep uint) error {
 	maxThreads := config.Cfg.Board.MaxThreads
 	if maxThreads == 0 {
 		return nil
@@ -165,11 +180,19 @@ func DeleteThreads(board Board) error {
 	if err := refreshBoard(&board, ^uint(0)); err != nil {
 		return err
 	}
-	if uint(len(board.Threads)) <= maxThreads {
+	threads := []Thread{}
+	for _, v := range board.Threads {
+		if v.ID != keep {
+			threads = append(threads, v)
+		}
+	}
+	if len(threads) < len(board.Threads) {
+		maxThreads--
+	}
+	if uint(len(threads)) <= maxThreads {
 		return nil
 	}
-	threads := board.Threads[maxThreads:len(board.Threads)]
-	for _, v := range threads {
+	for _, v := range threads[maxThreads:] {
 		err := Remove(v.Board.Name, int(v.Number))
 		if err != nil {
 			return err
@@ -179,9 +202,11 @@ func DeleteThreads(board Board) error {
 }
 
 func CreateThread(board Board, title string, name string, media string,
-	ip string, session string, account Account, signed bool,
-	rank bool, content template.HTML) (int, error) {
+	filename string, processing bool, ip string, session string,
+	account Account, signed bool, rank bool, content template.HTML,
+	state PostState) (int, uint, error) {
 	number := -1
+	var id uint
 	err := db.Transaction(func(tx *gorm.DB) error {
 		var err error
 		thread := &Thread{Board: board, Title: title, Alive: true}
@@ -192,8 +217,8 @@ func CreateThread(board Board, title string, name string, media string,
 		if err := ret.Find(&thread).Error; err != nil {
 			return err
 		}
-		number, err = CreatePost(*thread, content, name, media, ip,
-			session, account, signed, rank, false, tx)
+		number, id, err = CreatePost(*thread, content, name, media, filename,
+			processing, ip, session, account, signed, rank, false, state, tx)
 		if err != nil {
 			return err
 		}
@@ -203,7 +228,7 @@ func CreateThread(board Board, title string, name string, media string,
 	if err == nil {
 		err = DeleteThreads(board)
 	}
-	return number, err
+	return number, id, err
 }
 
 func UpdateBoard(board Board) error {
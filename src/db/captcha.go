@@ -0,0 +1,39 @@
+package db
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// CaptchaQuestion is a question asked instead of an image captcha,
+// Answers being a comma-separated list of accepted answers
+type CaptchaQuestion struct {
+	gorm.Model
+	CRUD[CaptchaQuestion]
+	Question string
+	Answers  string
+}
+
+func (q CaptchaQuestion) Accepts(answer string) bool {
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	for _, v := range strings.Split(q.Answers, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v != "" && v == answer {
+			return true
+		}
+	}
+	return false
+}
+
+func GetCaptchaQuestion(id uint) (CaptchaQuestion, error) {
+	var question CaptchaQuestion
+	err := db.First(&question, id).Error
+	return question, err
+}
+
+func CountCaptchaQuestions() (int64, error) {
+	var count int64
+	err := db.Model(&CaptchaQuestion{}).Count(&count).Error
+	return count, err
+}
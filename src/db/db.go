@@ -2,6 +2,7 @@ package db
 
 import (
 	"IB1/config"
+	"IB1/storage"
 	"errors"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -84,7 +85,9 @@ func Init() error {
 		&Reference{}, &Account{}, &Session{}, &Config{},
 		&Media{}, &Banner{}, &BannedImage{}, &Ban{},
 		&Rank{}, &MemberRank{}, &Membership{}, &Blacklist{},
-		&Wordfilter{}, &CIDR{}, &KeyValue{}, &ApprovalBypass{})
+		&Wordfilter{}, &CIDR{}, &KeyValue{}, &ApprovalBypass{},
+		&Redirect{}, &ModLog{}, &SpamToken{},
+		&CaptchaQuestion{}, &NotificationChannel{})
 
 	if err := LoadBoards(); err != nil {
 		return err
@@ -92,9 +95,18 @@ func Init() error {
 	if err := LoadBanList(); err != nil {
 		return err
 	}
+	if err := LoadBannedImages(); err != nil {
+		return err
+	}
 	if err := LoadConfig(); err != nil {
 		return err
 	}
+	if err := migrateNotificationURL(); err != nil {
+		return err
+	}
+	if err := storage.Load(); err != nil {
+		return err
+	}
 	go func() {
 		for {
 			exist, err := HasSuperuser()
@@ -154,7 +166,8 @@ func Init() error {
 			REMOVE_POST.String(),
 			BAN_USER.String(),
 		}...)
-		if err := CreateRank("Moderator", privs); err != nil {
+		if err := CreateRank("Moderator",
+			append(privs, MOVE_THREAD.String())); err != nil {
 			return err
 		}
 		if err := CreateMemberRank("Moderator", privs); err != nil {
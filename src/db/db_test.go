@@ -0,0 +1,23 @@
+package db
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain runs the tests against a database in a temporary directory
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "ib1-db")
+	if err != nil {
+		log.Fatal(err)
+	}
+	Path = filepath.Join(dir, "ib1.db")
+	if err := Init(); err != nil {
+		log.Fatal(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
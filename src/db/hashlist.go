@@ -0,0 +1,347 @@
+package db
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/corona10/goimagehash"
+)
+
+// HashEntry is a ban in a list imported or exported as CSV or JSON, the
+// hashes being written as "kind:hex" like goimagehash does or as decimal
+// integers, Difference and Perception being the other hashes of a ban
+// whose main hash is an average one
+type HashEntry struct {
+	Hash       string `json:"hash"`
+	Kind       string `json:"kind"`
+	Difference string `json:"difference,omitempty"`
+	Perception string `json:"perception,omitempty"`
+	Note       string `json:"note,omitempty"`
+	Source     string `json:"source,omitempty"`
+}
+
+// BannedSource is a list the banned hashes were imported from
+type BannedSource struct {
+	Source string
+	Count  int64
+}
+
+func parseKind(s string) (goimagehash.Kind, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "a", "average", "ahash":
+		return goimagehash.AHash, nil
+	case "d", "difference", "dhash":
+		return goimagehash.DHash, nil
+	case "p", "perception", "phash":
+		return goimagehash.PHash, nil
+	}
+	return goimagehash.Unknown, errors.New("unknown hash kind: " + s)
+}
+
+func kindName(kind goimagehash.Kind) string {
+	switch kind {
+	case goimagehash.DHash:
+		return "difference"
+	case goimagehash.PHash:
+		return "perception"
+	}
+	return "average"
+}
+
+// KindName returns the kind of the hash of a ban
+func (v BannedImage) KindName() string {
+	return kindName(goimagehash.Kind(v.Kind))
+}
+
+func formatHash(hash int64, kind goimagehash.Kind) string {
+	return fmt.Sprintf("%s:%016x", kindName(kind)[:1], uint64(hash))
+}
+
+// parseHashValue reads a hash and the kind it is prefixed with, if any
+func parseHashValue(s string) (*goimagehash.Kind, uint64, error) {
+	s = strings.TrimSpace(s)
+	var hash uint64
+	var err error
+	if prefix, hex, found := strings.Cut(s, ":"); found {
+		kind, err := parseKind(prefix)
+		if err != nil || prefix == "" {
+			return nil, 0, errors.New("unknown hash kind: " + prefix)
+		}
+		hash, err = strconv.ParseUint(hex, 16, 64)
+		if err != nil {
+			return nil, 0, errors.New("invalid hash: " + s)
+		}
+		return &kind, hash, nil
+	} else if v, found := strings.CutPrefix(s, "0x"); found {
+		hash, err = strconv.ParseUint(v, 16, 64)
+	} else if strings.HasPrefix(s, "-") {
+		var v int64
+		v, err = strconv.ParseInt(s, 10, 64)
+		hash = uint64(v)
+	} else {
+		hash, err = strconv.ParseUint(s, 10, 64)
+	}
+	if err != nil {
+		return nil, 0, errors.New("invalid hash: " + s)
+	}
+	return nil, hash, nil
+}
+
+// parseOtherHash reads the optional difference or perception hash of a
+// ban
+func parseOtherHash(s string, kind goimagehash.Kind) (*int64, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	prefix, hash, err := parseHashValue(s)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != nil && *prefix != kind {
+		return nil, errors.New("conflicting hash kinds")
+	}
+	v := int64(hash)
+	return &v, nil
+}
+
+func parseHash(entry HashEntry) (BannedImage, error) {
+	kind, err := parseKind(entry.Kind)
+	if err != nil {
+		return BannedImage{}, err
+	}
+	prefix, hash, err := parseHashValue(entry.Hash)
+	if err != nil {
+		return BannedImage{}, err
+	}
+	if prefix != nil {
+		if strings.TrimSpace(entry.Kind) != "" && *prefix != kind {
+			return BannedImage{}, errors.New("conflicting hash kinds")
+		}
+		kind = *prefix
+	}
+	ban := BannedImage{
+		Hash: int64(hash), Kind: int(kind),
+		Note:   strings.TrimSpace(entry.Note),
+		Source: strings.TrimSpace(entry.Source),
+	}
+	ban.Difference, err = parseOtherHash(entry.Difference,
+		goimagehash.DHash)
+	if err != nil {
+		return BannedImage{}, err
+	}
+	ban.Perception, err = parseOtherHash(entry.Perception,
+		goimagehash.PHash)
+	if err != nil {
+		return BannedImage{}, err
+	}
+	if kind != goimagehash.AHash &&
+		(ban.Difference != nil || ban.Perception != nil) {
+		return BannedImage{}, errors.New("only an average hash can be " +
+			"banned with other hashes")
+	}
+	return ban, nil
+}
+
+func parseCSV(data []byte) ([]HashEntry, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{
+		"hash": 0, "kind": 1, "note": 2, "source": 3, "difference": 4,
+		"perception": 5,
+	}
+	if len(records) > 0 {
+		header := map[string]int{}
+		for i, v := range records[0] {
+			header[strings.ToLower(strings.TrimSpace(v))] = i
+		}
+		if _, ok := header["hash"]; ok {
+			columns = header
+			records = records[1:]
+		}
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+	entries := []HashEntry{}
+	for _, v := range records {
+		if len(v) == 1 && strings.TrimSpace(v[0]) == "" {
+			continue
+		}
+		entries = append(entries, HashEntry{
+			Hash: field(v, "hash"), Kind: field(v, "kind"),
+			Difference: field(v, "difference"),
+			Perception: field(v, "perception"),
+			Note:       field(v, "note"), Source: field(v, "source"),
+		})
+	}
+	return entries, nil
+}
+
+// ParseHashList reads a list of banned hashes, as a JSON array or as CSV
+// with an optional header
+func ParseHashList(r io.Reader) ([]BannedImage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	var entries []HashEntry
+	if bytes.HasPrefix(data, []byte("[")) {
+		err = json.Unmarshal(data, &entries)
+	} else {
+		entries, err = parseCSV(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := []BannedImage{}
+	for i, v := range entries {
+		ban, err := parseHash(v)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		list = append(list, ban)
+	}
+	return list, nil
+}
+
+type hashKey struct {
+	hash int64
+	kind goimagehash.Kind
+}
+
+// hashKeys returns every hash held by a ban
+func hashKeys(v BannedImage) []hashKey {
+	kind := goimagehash.Kind(v.Kind)
+	if kind != goimagehash.DHash && kind != goimagehash.PHash {
+		kind = goimagehash.AHash
+	}
+	keys := []hashKey{{v.Hash, kind}}
+	if v.Difference != nil {
+		keys = append(keys, hashKey{*v.Difference, goimagehash.DHash})
+	}
+	if v.Perception != nil {
+		keys = append(keys, hashKey{*v.Perception, goimagehash.PHash})
+	}
+	return keys
+}
+
+// ImportBannedImages bans the hashes of a list that are not banned yet,
+// tagging them with source if it is not empty, and returns the new bans
+// and the number of duplicates skipped
+func ImportBannedImages(list []BannedImage, source string) ([]BannedImage,
+	int, error) {
+	existing, err := GetBannedImages()
+	if err != nil {
+		return nil, 0, err
+	}
+	seen := map[hashKey]bool{}
+	for _, v := range existing {
+		for _, key := range hashKeys(v) {
+			seen[key] = true
+		}
+	}
+	imported := []BannedImage{}
+	for _, v := range list {
+		key := hashKeys(v)[0]
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if source != "" {
+			v.Source = source
+		}
+		imported = append(imported, v)
+	}
+	duplicates := len(list) - len(imported)
+	if len(imported) == 0 {
+		return imported, duplicates, nil
+	}
+	if err := db.CreateInBatches(&imported, 100).Error; err != nil {
+		return nil, 0, err
+	}
+	return imported, duplicates, LoadBannedImages()
+}
+
+// ExportBannedImages writes the bans, or the ones of a source, as "csv" or
+// "json", each with all of its hashes
+func ExportBannedImages(w io.Writer, format string, source string) error {
+	var list []BannedImage
+	tx := db.Order("id")
+	if source != "" {
+		tx = tx.Where("source = ?", source)
+	}
+	if err := tx.Find(&list).Error; err != nil {
+		return err
+	}
+	entries := []HashEntry{}
+	for _, v := range list {
+		keys := hashKeys(v)
+		entry := HashEntry{
+			Hash: formatHash(keys[0].hash, keys[0].kind),
+			Kind: kindName(keys[0].kind), Note: v.Note, Source: v.Source,
+		}
+		if v.Difference != nil {
+			entry.Difference = formatHash(*v.Difference, goimagehash.DHash)
+		}
+		if v.Perception != nil {
+			entry.Perception = formatHash(*v.Perception, goimagehash.PHash)
+		}
+		entries = append(entries, entry)
+	}
+	switch format {
+	case "json":
+		e := json.NewEncoder(w)
+		e.SetIndent("", "\t")
+		return e.Encode(entries)
+	case "csv":
+		c := csv.NewWriter(w)
+		c.Write([]string{
+			"hash", "kind", "note", "source", "difference", "perception",
+		})
+		for _, v := range entries {
+			c.Write([]string{
+				v.Hash, v.Kind, v.Note, v.Source, v.Difference,
+				v.Perception,
+			})
+		}
+		c.Flush()
+		return c.Error()
+	}
+	return errors.New("unknown format: " + format)
+}
+
+func GetBannedSources() ([]BannedSource, error) {
+	var sources []BannedSource
+	err := db.Model(&BannedImage{}).Select("source, count(*) AS count").
+		Where("source <> ''").Group("source").Order("source").
+		Scan(&sources).Error
+	return sources, err
+}
+
+// RemoveBannedSource lifts the bans imported from a list
+func RemoveBannedSource(source string) error {
+	if source == "" {
+		return errors.New("no source given")
+	}
+	err := db.Where("source = ?", source).Delete(&BannedImage{}).Error
+	if err != nil {
+		return err
+	}
+	return LoadBannedImages()
+}
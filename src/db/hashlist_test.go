@@ -0,0 +1,106 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/corona10/goimagehash"
+)
+
+func ptr(v int64) *int64 {
+	return &v
+}
+
+func TestParseHash(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry HashEntry
+		want  BannedImage
+		valid bool
+	}{
+		{"decimal", HashEntry{Hash: "255"},
+			BannedImage{Hash: 255, Kind: int(goimagehash.AHash)}, true},
+		{"negative decimal", HashEntry{Hash: "-1"},
+			BannedImage{Hash: -1, Kind: int(goimagehash.AHash)}, true},
+		{"hexadecimal", HashEntry{Hash: "0xff", Kind: "dhash"},
+			BannedImage{Hash: 255, Kind: int(goimagehash.DHash)}, true},
+		{"prefixed", HashEntry{Hash: " p:ffffffffffffffff "},
+			BannedImage{Hash: -1, Kind: int(goimagehash.PHash)}, true},
+		{"matching kinds", HashEntry{Hash: "d:10", Kind: "difference"},
+			BannedImage{Hash: 16, Kind: int(goimagehash.DHash)}, true},
+		{"note and source", HashEntry{Hash: "1", Note: " gore ",
+			Source: " list "},
+			BannedImage{Hash: 1, Kind: int(goimagehash.AHash),
+				Note: "gore", Source: "list"}, true},
+		{"other hashes", HashEntry{Hash: "a:01", Difference: "d:02",
+			Perception: "3"},
+			BannedImage{Hash: 1, Kind: int(goimagehash.AHash),
+				Difference: ptr(2), Perception: ptr(3)}, true},
+		{"conflicting kinds", HashEntry{Hash: "d:01", Kind: "perception"},
+			BannedImage{}, false},
+		{"conflicting other kind", HashEntry{Hash: "1", Difference: "p:02"},
+			BannedImage{}, false},
+		{"other hashes on a difference hash", HashEntry{Hash: "d:01",
+			Perception: "3"}, BannedImage{}, false},
+		{"unknown kind", HashEntry{Hash: "1", Kind: "colour"},
+			BannedImage{}, false},
+		{"unknown prefix", HashEntry{Hash: "x:01"}, BannedImage{}, false},
+		{"empty prefix", HashEntry{Hash: ":01"}, BannedImage{}, false},
+		{"invalid hexadecimal", HashEntry{Hash: "a:zz"},
+			BannedImage{}, false},
+		{"too large", HashEntry{Hash: "18446744073709551616"},
+			BannedImage{}, false},
+		{"empty", HashEntry{}, BannedImage{}, false},
+	}
+	for _, test := range tests {
+		got, err := parseHash(test.entry)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: parseHash() = %v, want valid = %v", test.name,
+				err, test.valid)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parseHash() = %+v, want %+v", test.name, got,
+				test.want)
+		}
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		want  []HashEntry
+		valid bool
+	}{
+		{"default columns", "a:01,average,note,src,d:02,p:03\n2\n",
+			[]HashEntry{
+				{Hash: "a:01", Kind: "average", Note: "note", Source: "src",
+					Difference: "d:02", Perception: "p:03"},
+				{Hash: "2"},
+			}, true},
+		{"header", "hash,note\n1,spam\n",
+			[]HashEntry{{Hash: "1", Note: "spam"}}, true},
+		{"reordered header", "Kind, hash, source\nd,0x10,list\n",
+			[]HashEntry{{Hash: "0x10", Kind: "d", Source: "list"}}, true},
+		{"blank lines", "1\n\n2\n", []HashEntry{{Hash: "1"}, {Hash: "2"}},
+			true},
+		{"quoted", "1,,\"a, b\"\n",
+			[]HashEntry{{Hash: "1", Note: "a, b"}}, true},
+		{"header only", "hash,kind\n", []HashEntry{}, true},
+		{"empty", "", []HashEntry{}, true},
+		{"invalid quotes", "1,\"a\"b\n", nil, false},
+	}
+	for _, test := range tests {
+		got, err := parseCSV([]byte(test.data))
+		if (err == nil) != test.valid {
+			t.Errorf("%s: parseCSV() = %v, want valid = %v", test.name,
+				err, test.valid)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parseCSV() = %+v, want %+v", test.name, got,
+				test.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/corona10/goimagehash"
+
+	"IB1/config"
+	"IB1/util"
+)
+
+// ImageHashes are the perceptual hashes computed for an image
+type ImageHashes struct {
+	Average    uint64
+	Difference uint64
+	Perception uint64
+}
+
+type imageIndex struct {
+	average    util.BKTree[uint]
+	difference util.BKTree[uint]
+	perception util.BKTree[uint]
+	bans       map[uint]BannedImage
+	mutex      sync.RWMutex
+}
+
+var bannedImages = &imageIndex{}
+
+// newImageIndex indexes banned hashes by kind, the difference and
+// perception hashes of a banned media being stored with its average hash
+func newImageIndex(list []BannedImage) *imageIndex {
+	index := &imageIndex{bans: map[uint]BannedImage{}}
+	for _, v := range list {
+		index.bans[v.ID] = v
+		switch goimagehash.Kind(v.Kind) {
+		case goimagehash.DHash:
+			index.difference.Insert(uint64(v.Hash), v.ID)
+		case goimagehash.PHash:
+			index.perception.Insert(uint64(v.Hash), v.ID)
+		default:
+			index.average.Insert(uint64(v.Hash), v.ID)
+		}
+		if v.Difference != nil {
+			index.difference.Insert(uint64(*v.Difference), v.ID)
+		}
+		if v.Perception != nil {
+			index.perception.Insert(uint64(*v.Perception), v.ID)
+		}
+	}
+	return index
+}
+
+func LoadBannedImages() error {
+	list, err := GetBannedImages()
+	if err != nil {
+		return err
+	}
+	index := newImageIndex(list)
+	bannedImages.mutex.Lock()
+	bannedImages.average = index.average
+	bannedImages.difference = index.difference
+	bannedImages.perception = index.perception
+	bannedImages.bans = index.bans
+	bannedImages.mutex.Unlock()
+	return nil
+}
+
+type hashCheck struct {
+	tree      *util.BKTree[uint]
+	hash      uint64
+	threshold int
+	kind      goimagehash.Kind
+}
+
+// has reports whether a ban holds a hash of the kind of the check
+func (check hashCheck) has(v BannedImage) bool {
+	kind := goimagehash.Kind(v.Kind)
+	switch check.kind {
+	case goimagehash.DHash:
+		return v.Difference != nil || kind == check.kind
+	case goimagehash.PHash:
+		return v.Perception != nil || kind == check.kind
+	}
+	return kind != goimagehash.DHash && kind != goimagehash.PHash
+}
+
+func (index *imageIndex) banned(hashes ImageHashes) bool {
+	cfg := config.Cfg.Media
+	checks := []hashCheck{
+		{&index.average, hashes.Average, cfg.ImageThreshold,
+			goimagehash.AHash},
+		{&index.difference, hashes.Difference,
+			cfg.DifferenceThreshold, goimagehash.DHash},
+		{&index.perception, hashes.Perception,
+			cfg.PerceptionThreshold, goimagehash.PHash},
+	}
+	matches := map[uint]int{}
+	for _, check := range checks {
+		if check.threshold <= 0 {
+			continue
+		}
+		for _, id := range check.tree.Search(check.hash,
+			check.threshold-1) {
+			matches[id]++
+		}
+	}
+	for id, n := range matches {
+		if !cfg.MatchAllHashes {
+			return true
+		}
+		expected := 0
+		for _, check := range checks {
+			if check.threshold > 0 && check.has(index.bans[id]) {
+				expected++
+			}
+		}
+		if n >= expected {
+			return true
+		}
+	}
+	return false
+}
+
+func IsImageBanned(hashes ImageHashes) error {
+	bannedImages.mutex.RLock()
+	defer bannedImages.mutex.RUnlock()
+	if bannedImages.banned(hashes) {
+		return errors.New("banned image")
+	}
+	return nil
+}
+
+// ImageMatcher returns a function reporting whether the hashes of an image
+// match one of the bans of a list
+func ImageMatcher(list []BannedImage) func(ImageHashes) bool {
+	return newImageIndex(list).banned
+}
+
+// BanImage bans the hashes of a media, one for each of its frames
+func BanImage(media string, hashes ...ImageHashes) error {
+	list := []BannedImage{}
+	for _, v := range hashes {
+		difference := int64(v.Difference)
+		perception := int64(v.Perception)
+		list = append(list, BannedImage{
+			Hash:       int64(v.Average),
+			Kind:       int(goimagehash.AHash),
+			Difference: &difference,
+			Perception: &perception,
+			Media:      media,
+		})
+	}
+	if err := db.Create(&list).Error; err != nil {
+		return err
+	}
+	return LoadBannedImages()
+}
+
+func GetBannedImages() ([]BannedImage, error) {
+	var v []BannedImage
+	err := db.Find(&v).Error
+	return v, err
+}
+
+// AddBannedImage bans an average hash and optionally the difference and
+// perception hashes of the same image
+func AddBannedImage(average int64, difference *int64,
+	perception *int64, note string) error {
+	err := db.Create(&BannedImage{
+		Hash: average, Kind: int(goimagehash.AHash),
+		Difference: difference, Perception: perception, Note: note,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return LoadBannedImages()
+}
+
+// RemoveBannedImage lifts a ban along with the other frames of its media
+func RemoveBannedImage(id int) error {
+	var v BannedImage
+	if err := db.First(&v, id).Error; err != nil {
+		return err
+	}
+	tx := db.Where("id = ?", id)
+	if v.Media != "" {
+		tx = tx.Or("media = ?", v.Media)
+	}
+	if err := tx.Delete(&BannedImage{}).Error; err != nil {
+		return err
+	}
+	return LoadBannedImages()
+}
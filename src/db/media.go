@@ -3,15 +3,12 @@ package db
 import (
 	"errors"
 	"log"
-	"os"
-	"path/filepath"
-	"strings"
 	"time"
 
-	"github.com/corona10/goimagehash"
+	"github.com/gabriel-vasile/mimetype"
 	"gorm.io/gorm"
 
-	"IB1/config"
+	"IB1/storage"
 )
 
 type MediaType uint8
@@ -22,20 +19,99 @@ const (
 	MEDIA_AUDIO
 )
 
+func (t MediaType) String() string {
+	switch t {
+	case MEDIA_PICTURE:
+		return "picture"
+	case MEDIA_VIDEO:
+		return "video"
+	case MEDIA_AUDIO:
+		return "audio"
+	}
+	return "unknown"
+}
+
+// MediaInfo holds the properties of a media found while processing it
+type MediaInfo struct {
+	Duration float64
+	Cover    bool
+	Width    int
+	Height   int
+}
+
 type Media struct {
-	Hash          string `gorm:"unique"`
-	Mime          string
-	Data          []byte
-	Thumbnail     []byte
-	Approved      bool
-	HideThumbnail bool
-	Type          MediaType
+	Hash      string `gorm:"unique"`
+	Mime      string
+	Data      []byte
+	Thumbnail []byte
+	// thumbnail of the replies, only made when its size differs
+	ReplyThumbnail     []byte
+	ThumbnailExtension string
+	Approved           bool
+	ApprovedBy         string
+	HideThumbnail      bool
+	Type               MediaType
+	Extension          string
+	Size               int64
+	CreatedAt          time.Time
+	MediaInfo          `gorm:"embedded"`
+}
+
+// Key returns the name of the file of the media in the storage
+func (m Media) Key() string {
+	extension := m.Extension
+	if extension == "" {
+		extension = mimetype.Lookup(m.Mime).Extension()
+		if extension == ".oga" {
+			extension = ".ogg"
+		}
+	}
+	return m.Hash + extension
+}
+
+func (m Media) thumbnailExtension() string {
+	if m.ThumbnailExtension == "" {
+		return ".png"
+	}
+	return m.ThumbnailExtension
+}
+
+func (m Media) ThumbnailKey() string {
+	return "thumbnail/" + m.Hash + m.thumbnailExtension()
+}
+
+func (m Media) ReplyThumbnailKey() string {
+	return "thumbnail/reply/" + m.Hash + m.thumbnailExtension()
+}
+
+// Keys returns the names of every file of the media in the storage
+func (m Media) Keys() []string {
+	return []string{m.Key(), m.ThumbnailKey(), m.ReplyThumbnailKey()}
 }
 
+// removeFiles deletes the media and its thumbnails from the storage
+func (m Media) removeFiles() error {
+	for _, key := range m.Keys() {
+		if err := storage.Media().Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BannedImage holds the perceptual hashes of a banned media or of one of
+// its frames, Hash being the average hash, or a hash of another kind
+// imported from a list
 type BannedImage struct {
 	gorm.Model
-	Hash int64
-	Kind int
+	Hash       int64
+	Kind       int
+	Difference *int64
+	Perception *int64
+	Media      string `gorm:"index"`
+	Note       string
+	// Source is the list the hash was imported from
+	Source string `gorm:"index"`
 }
 
 type ApprovalBypass struct {
@@ -63,62 +139,138 @@ func CanBypassApproval(hash string, secret string) error {
 	return nil
 }
 
-func AddMedia(data []byte, thumbnail []byte, mediaType MediaType, hash string,
-	mime string, approved bool, spoiler bool) (bool, error) {
-	var media Media
+// AddMedia adds a media and saves its files with store, the media being
+// removed if they could not all be saved, returning if it has to be
+// approved
+func AddMedia(media Media, store func() error) (bool, error) {
+	mediaStoreLock.RLock()
+	defer mediaStoreLock.RUnlock()
 	var count int64
-	db.First(&media, "hash = ?", hash).Count(&count)
+	db.Model(&Media{}).Where("hash = ?", media.Hash).Count(&count)
 	if count > 0 {
 		return false, nil
 	}
-	err := db.Create(&Media{
-		Hash: hash, Mime: mime, Data: data, Thumbnail: thumbnail,
-		Approved: approved, Type: mediaType, HideThumbnail: spoiler,
-	}).Error
-	return err == nil && !approved, err
+	if err := db.Create(&media).Error; err != nil {
+		return false, err
+	}
+	if err := store(); err != nil {
+		for _, key := range media.Keys() {
+			storage.Media().Delete(key)
+		}
+		db.Where("hash = ?", media.Hash).Delete(&Media{})
+		return false, err
+	}
+	return !media.Approved, nil
 }
 
-func GetThumbnail(hash string) ([]byte, error) {
-	var media Media
-	err := db.Select("thumbnail").First(&media, "hash = ?", hash).Error
-	if err != nil {
-		return nil, err
+// MediaFilter selects the media listed in the media library
+type MediaFilter struct {
+	Type    string
+	State   string
+	Spoiler bool
+	Board   string
+	Hash    string
+	// restricts the media to the ones posted on these boards if not nil
+	BoardIDs    []uint
+	OldestFirst bool
+}
+
+func (f MediaFilter) query() *gorm.DB {
+	query := db.Model(&Media{})
+	for _, v := range []MediaType{MEDIA_PICTURE, MEDIA_VIDEO, MEDIA_AUDIO} {
+		if f.Type == v.String() {
+			query = query.Where("type = ?", v)
+		}
+	}
+	switch f.State {
+	case "approved":
+		query = query.Where("approved = ?", true)
+	case "pending":
+		query = query.Where("approved = ? OR approved IS NULL", false)
+	}
+	if f.Spoiler {
+		query = query.Where("hide_thumbnail = ?", true)
+	}
+	if f.Hash != "" {
+		query = query.Where("hash LIKE ?", f.Hash+"%")
 	}
-	return media.Thumbnail, nil
+	if board, ok := Boards[f.Board]; ok {
+		query = query.Where("hash IN (?)", db.Model(&Post{}).
+			Select("media_hash").Where("board_id = ?", board.ID))
+	}
+	if f.BoardIDs != nil {
+		query = query.Where("hash IN (?)", db.Model(&Post{}).
+			Select("media_hash").Where("board_id IN ?", f.BoardIDs))
+	}
+	return query
 }
 
-func GetMediaData(hash string) ([]byte, string, error) {
-	var media Media
-	err := db.Select("data", "mime").First(&media, "hash = ?", hash).Error
+// MediaEntry is a media of the media library with the posts using it
+type MediaEntry struct {
+	Media
+	Posts []Post
+}
+
+// GetMedias returns a page of the media matching a filter, newest first,
+// and the number of media matching it
+func GetMedias(filter MediaFilter, page int,
+	perPage int) ([]MediaEntry, int64, error) {
+	var count int64
+	if err := filter.query().Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	order := "created_at desc, hash"
+	if filter.OldestFirst {
+		order = "created_at, hash"
+	}
+	var medias []Media
+	err := filter.query().Omit(mediaFiles...).Order(order).
+		Offset(page * perPage).Limit(perPage).Find(&medias).Error
 	if err != nil {
-		return nil, "", err
+		return nil, 0, err
+	}
+	hashes := []string{}
+	for _, v := range medias {
+		hashes = append(hashes, v.Hash)
+	}
+	var posts []Post
+	err = db.Preload("Board").Preload("Thread").
+		Where("media_hash IN ?", hashes).Order("id").Find(&posts).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	used := map[string][]Post{}
+	for _, v := range posts {
+		used[v.MediaHash] = append(used[v.MediaHash], v)
 	}
-	return media.Data, media.Mime, nil
+	entries := []MediaEntry{}
+	for _, v := range medias {
+		entries = append(entries, MediaEntry{v, used[v.Hash]})
+	}
+	return entries, count, nil
+}
+
+func SetSpoiler(hash string, spoiler bool) error {
+	return db.Model(&Media{}).Where("hash = ?", hash).
+		Update("hide_thumbnail", spoiler).Error
 }
 
 func cleanOrphanMedias() error {
 	join := "LEFT OUTER JOIN posts b ON a.hash = b.media_hash " +
 		"WHERE b.media_hash IS NULL"
 	query := "SELECT a.hash FROM media a " + join
-	if !config.Cfg.Media.InDatabase {
-		var orphans []Media
-		if err := db.Raw(query).Scan(&orphans).Error; err != nil {
-			return err
-		}
-		if len(orphans) == 0 {
-			return nil
-		}
-		for _, v := range orphans {
-			files, err := filepath.Glob(
-				config.Cfg.Media.Path + "/" + v.Hash + ".*")
-			if err != nil {
-				continue
-			}
-			for _, v := range files {
-				os.Remove(v)
-			}
-			os.Remove(config.Cfg.Media.Path +
-				"/thumbnail/" + v.Hash + ".png")
+	var orphans []Media
+	err := db.Raw("SELECT a.hash, a.mime, a.extension, " +
+		"a.thumbnail_extension FROM media a " + join).Scan(&orphans).Error
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		return nil
+	}
+	for _, v := range orphans {
+		if err := v.removeFiles(); err != nil {
+			log.Println(err)
 		}
 	}
 	if dbType == TYPE_MYSQL {
@@ -136,53 +288,72 @@ func cleanMediaTask() {
 	}
 }
 
-func GetPendingApproval() (string, string, error) {
-	var media Media
-	err := db.First(&media, "approved = 0").Error
-	if err != nil {
-		err = db.First(&media, "approved IS NULL").Error
-	}
-	return media.Hash, media.Mime, err
-}
-
-func Approve(hash string) error {
-	return db.Model(&Media{}).Where("hash = ?", hash).
-		Update("approved", true).Error
+// Approve approves a media on behalf of an account
+func Approve(hash string, by string) error {
+	return db.Model(&Media{}).Where("hash = ?", hash).Updates(
+		map[string]interface{}{"approved": true, "approved_by": by}).Error
 }
 
-func ApproveAll() error {
-	return db.Model(&Media{}).Where("1 = 1").Update("approved", true).Error
+func ApproveAll(by string) error {
+	return db.Model(&Media{}).
+		Where("approved = ? OR approved IS NULL", false).Updates(
+		map[string]interface{}{"approved": true, "approved_by": by}).Error
 }
 
-func HasUnapproved() bool {
-	return db.Where("approved = ?", false).
-		First(&Media{}).RowsAffected != 0
+// DenyMedia removes a media and detaches it from its posts, which are
+// returned so their posters can be told
+func DenyMedia(hash string) ([]Post, error) {
+	var posts []Post
+	err := db.Preload("Board").Where("media_hash = ?", hash).
+		Find(&posts).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(&Post{}).Where("media_hash = ?", hash).Updates(
+		map[string]interface{}{
+			"media": "", "media_hash": "", "filename": "",
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+	return posts, RemoveMedia(hash)
 }
 
 func RemoveMedia(hash string) error {
-	if !config.Cfg.Media.InDatabase {
-		files, err := filepath.Glob(
-			config.Cfg.Media.Path + "/" + hash + ".*")
-		if err != nil {
-			return err
-		}
-		for _, v := range files {
-			os.Remove(v)
-		}
-		os.Remove(
-			config.Cfg.Media.Path + "/thumbnail/" + hash + ".png")
+	media, err := GetMedia(hash)
+	if err != nil {
+		return err
+	}
+	if err := media.removeFiles(); err != nil {
+		return err
 	}
 	return db.Where("hash = ?", hash).Delete(&Media{}).Error
 }
 
 const NoYetApproved = "media is not yet approved"
 
+// mediaFiles are the columns holding the files of the database storage
+var mediaFiles = []string{"data", "thumbnail", "reply_thumbnail"}
+
 func GetMedia(hash string) (Media, error) {
 	var media Media
-	err := db.First(&media, "hash = ?", hash).Error
+	err := db.Omit(mediaFiles...).First(&media, "hash = ?", hash).Error
 	return media, err
 }
 
+// GetAllMedia returns every media without the files of the database
+// storage
+func GetAllMedia() ([]Media, error) {
+	var medias []Media
+	err := db.Omit(mediaFiles...).Find(&medias).Error
+	return medias, err
+}
+
+func SetThumbnailExtension(hash string, extension string) error {
+	return db.Model(&Media{}).Where("hash = ?", hash).
+		Update("thumbnail_extension", extension).Error
+}
+
 func HasSpoiler(hash string) (bool, error) {
 	var media Media
 	err := db.First(&media, "hash = ?", hash).Error
@@ -202,126 +373,40 @@ func ToggleSpoiler(hash string) error {
 		Update("hide_thumbnail", !media.HideThumbnail).Error
 }
 
-func IsImageBanned(hash goimagehash.ImageHash) error {
-	rows, err := db.Model(&BannedImage{}).Select("hash, kind").Rows()
+// copyMedias copies the files of every media from a storage to another
+func copyMedias(src storage.Storage, dst storage.Storage) error {
+	medias, err := GetAllMedia()
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var v BannedImage
-		if err := db.ScanRows(rows, &v); err != nil {
-			return err
-		}
-		img := goimagehash.NewImageHash(
-			uint64(v.Hash), goimagehash.Kind(v.Kind))
-		distance, err := hash.Distance(img)
-		if err != nil {
-			return err
-		}
-		if distance < config.Cfg.Media.ImageThreshold {
-			return errors.New("banned image")
+	for _, v := range medias {
+		for _, key := range v.Keys() {
+			err := storage.Copy(src, dst, key)
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
-func BanImage(hash goimagehash.ImageHash) error {
-	return db.Create(&BannedImage{
-		Hash: int64(hash.GetHash()), Kind: int(hash.GetKind()),
-	}).Error
-}
-
-func GetBannedImages() ([]BannedImage, error) {
-	var v []BannedImage
-	err := db.Find(&v).Error
-	return v, err
-}
-
-func AddBannedImage(hash int64) error {
-	return db.Create(&BannedImage{Hash: hash}).Error
-}
-
-func RemoveBannedImage(hash int64) error {
-	return db.Where("hash = ?", hash).Delete(&BannedImage{}).Error
-}
-
+// Extract copies the media of the current storage to a directory
 func Extract(path string) error {
-	rows, err := db.Table("media").
-		Select("media.hash, media.data, media.thumbnail, posts.media").
-		Joins("inner join posts on media.hash = posts.media_hash").
-		Rows()
+	dst, err := storage.NewFilesystem(path)
 	if err != nil {
 		return err
 	}
-	err = os.MkdirAll(path+"/thumbnail", 0755)
-	if err != nil {
-		return err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var v struct {
-			Hash      string
-			Data      []byte
-			Thumbnail []byte
-			Media     string
-		}
-
-		if err := db.ScanRows(rows, &v); err != nil {
-			return err
-		}
-		if len(v.Data) == 0 {
-			continue
-		}
-
-		f, err := os.Create(path + "/" + v.Media)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		_, err = f.Write(v.Data)
-		if err != nil {
-			return err
-		}
-		f.Close()
-
-		f, err = os.Create(path + "/thumbnail/" + v.Hash + ".png")
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		_, err = f.Write(v.Thumbnail)
-		if err != nil {
-			return err
-		}
-		f.Close()
-	}
-	return nil
+	return copyMedias(storage.Media(), dst)
 }
 
+// Load copies the media of a directory to the current storage
 func Load(path string) error {
-	dir, err := os.ReadDir(path)
+	src, err := storage.NewFilesystem(path)
 	if err != nil {
 		return err
 	}
-	for _, v := range dir {
-		if !v.Type().IsRegular() {
-			continue
-		}
-		hash := strings.Split(v.Name(), ".")[0]
-		data, err := os.ReadFile(path + "/" + v.Name())
-		if err != nil {
-			return err
-		}
-		thumbnail, err := os.ReadFile(
-			path + "/thumbnail/" + hash + ".png")
-		if err != nil {
-			return err
-		}
-		db.Model(&Media{}).Where("hash = ?", hash).Updates(
-			map[string]interface{}{
-				"data": data, "thumnbail": thumbnail,
-			})
-	}
-	return nil
+	return copyMedias(src, storage.Media())
 }
@@ -0,0 +1,291 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/blake2b"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"IB1/config"
+	"IB1/storage"
+)
+
+// Migration is the progress of a move of the media to another storage
+type Migration struct {
+	From    string
+	To      string
+	Total   int64
+	Moved   int64
+	Running bool
+	Done    bool
+	Error   string
+	// Warning tells why the files copied to the new storage were left in
+	// the previous one
+	Warning string
+}
+
+const migrationBatch = 100
+
+var migration Migration
+var migrationLock sync.Mutex
+
+// mediaStoreLock is held by the uploads while they store their files, and
+// by the migration while it copies the last media and switches the storage
+var mediaStoreLock sync.RWMutex
+
+func GetMigration() Migration {
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+	return migration
+}
+
+func setMigration(f func(*Migration)) Migration {
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+	f(&migration)
+	return migration
+}
+
+// StartMigration moves the media to a storage in the background
+func StartMigration(target storage.Target) error {
+	dst, err := beginMigration(target)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := migrate(target, dst, nil); err != nil {
+			log.Println("media migration failed:", err)
+		}
+	}()
+	return nil
+}
+
+// Migrate moves every media and thumbnail from the current storage to
+// target, the configuration only being switched once every file has been
+// copied and verified
+func Migrate(target storage.Target, progress func(Migration)) error {
+	dst, err := beginMigration(target)
+	if err != nil {
+		return err
+	}
+	return migrate(target, dst, progress)
+}
+
+func beginMigration(target storage.Target) (storage.Storage, error) {
+	if target.Same(storage.Configured()) {
+		return nil, errors.New("the media are already in this storage")
+	}
+	dst, err := storage.Open(target)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	if err := db.Model(&Media{}).Count(&total).Error; err != nil {
+		return nil, err
+	}
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+	if migration.Running {
+		return nil, errors.New("a media migration is already running")
+	}
+	migration = Migration{
+		From: storage.Current(), To: target.Backend, Total: total,
+		Running: true,
+	}
+	return dst, nil
+}
+
+func migrate(target storage.Target, dst storage.Storage,
+	progress func(Migration)) error {
+	src := storage.Media()
+	start := time.Now()
+	err := moveMedias(src, dst, func() {
+		m := setMigration(func(m *Migration) {
+			m.Moved++
+			m.Total = max(m.Total, m.Moved)
+		})
+		if progress != nil {
+			progress(m)
+		}
+	})
+	if err == nil {
+		// copy the media uploaded while the others were being moved
+		start, err = copyNewMedias(src, dst, start)
+	}
+	if err == nil {
+		// the uploads wait while the last ones are copied so that no
+		// media is left behind once the new storage is used
+		mediaStoreLock.Lock()
+		_, err = copyNewMedias(src, dst, start)
+		if err == nil {
+			err = switchStorage(target, dst)
+		}
+		mediaStoreLock.Unlock()
+	}
+	if err != nil {
+		setMigration(func(m *Migration) {
+			m.Running = false
+			m.Error = err.Error()
+		})
+		return err
+	}
+
+	// the files are only removed once the new storage is used
+	err = removeMedias(src)
+	setMigration(func(m *Migration) {
+		m.Running = false
+		m.Done = true
+		if err != nil {
+			m.Warning = "the previous storage was not emptied: " +
+				err.Error()
+		}
+	})
+	if err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+// copyNewMedias copies the files of the media created since a time, and
+// returns when it started looking for them
+func copyNewMedias(src storage.Storage, dst storage.Storage,
+	since time.Time) (time.Time, error) {
+	now := time.Now()
+	var medias []Media
+	err := db.Omit(mediaFiles...).Where("created_at >= ?", since).
+		Find(&medias).Error
+	if err != nil {
+		return since, err
+	}
+	for _, v := range medias {
+		if err := copyMedia(src, dst, v); err != nil {
+			return since, err
+		}
+	}
+	return now, nil
+}
+
+// forEachMedia calls f on every media by batch
+func forEachMedia(f func(Media) error) error {
+	last := ""
+	for {
+		var medias []Media
+		err := db.Omit(mediaFiles...).Where("hash > ?", last).
+			Order("hash").Limit(migrationBatch).Find(&medias).Error
+		if err != nil {
+			return err
+		}
+		if len(medias) == 0 {
+			return nil
+		}
+		for _, v := range medias {
+			if err := f(v); err != nil {
+				return err
+			}
+		}
+		last = medias[len(medias)-1].Hash
+	}
+}
+
+// moveMedias copies the files of the media to dst
+func moveMedias(src storage.Storage, dst storage.Storage,
+	moved func()) error {
+	return forEachMedia(func(v Media) error {
+		if err := copyMedia(src, dst, v); err != nil {
+			return err
+		}
+		moved()
+		return nil
+	})
+}
+
+// removeMedias removes the files of the media from src
+func removeMedias(src storage.Storage) error {
+	return forEachMedia(func(v Media) error {
+		for _, key := range v.Keys() {
+			err := src.Delete(key)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				log.Println(err)
+			}
+		}
+		return nil
+	})
+}
+
+// copyMedia copies the files of a media and verifies them once written
+func copyMedia(src storage.Storage, dst storage.Storage, media Media) error {
+	for _, key := range media.Keys() {
+		err := copyVerified(src, dst, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h, err := blake2b.New256(config.Cfg.Media.Key)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// copyVerified copies a file and checks that the copy has the hash of the
+// original
+func copyVerified(src storage.Storage, dst storage.Storage,
+	key string) error {
+	r, err := src.Get(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	hash, err := hashReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if err := dst.Put(key, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	w, err := dst.Get(key)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	copied, err := hashReader(w)
+	if err != nil {
+		return err
+	}
+	if copied != hash {
+		return errors.New("the copied file does not match the original")
+	}
+	return nil
+}
+
+func switchStorage(target storage.Target, dst storage.Storage) error {
+	previous := config.Cfg.Media
+	config.Cfg.Media.Storage = target.Backend
+	config.Cfg.Media.InDatabase = target.Backend == "database"
+	config.Cfg.Media.Path = target.Path
+	config.Cfg.Media.S3 = target.S3
+	if err := UpdateConfig(); err != nil {
+		config.Cfg.Media = previous
+		return err
+	}
+	storage.SetMedia(dst)
+	return nil
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"IB1/config"
+	"IB1/storage"
+)
+
+// hookedStorage runs a function before every file is written
+type hookedStorage struct {
+	storage.Storage
+	put func(key string) error
+}
+
+func (s hookedStorage) Put(key string, r io.Reader) error {
+	if err := s.put(key); err != nil {
+		return err
+	}
+	return s.Storage.Put(key, r)
+}
+
+func addTestMedia(t *testing.T, hash string) {
+	t.Helper()
+	media := Media{Hash: hash, Extension: ".png", Approved: true}
+	_, err := AddMedia(media, func() error {
+		return storage.Media().Put(media.Key(), strings.NewReader(hash))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	previous := config.Cfg.Media
+	defer func() { config.Cfg.Media = previous }()
+	defer storage.SetMedia(storage.Media())
+
+	tests := []struct {
+		name string
+		fail string
+	}{
+		{"late media failing to copy", "migratea.png"},
+		{"late media copied", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			srcPath := filepath.Join(dir, "src")
+			dstPath := filepath.Join(dir, "dst")
+			config.Cfg.Media.Storage = "filesystem"
+			config.Cfg.Media.Path = srcPath
+			src, err := storage.NewFilesystem(srcPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			storage.SetMedia(src)
+			err = db.Where("hash LIKE ?", "migrate%").
+				Delete(&Media{}).Error
+			if err != nil {
+				t.Fatal(err)
+			}
+			addTestMedia(t, "migratez")
+
+			target := storage.Target{Backend: "filesystem", Path: dstPath}
+			dst, err := beginMigration(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			uploaded := false
+			hooked := hookedStorage{dst, func(key string) error {
+				// a media is uploaded while the others are moved
+				if !uploaded {
+					uploaded = true
+					addTestMedia(t, "migratea")
+				}
+				if key == test.fail {
+					return errors.New("write failed")
+				}
+				return nil
+			}}
+			err = migrate(target, hooked, nil)
+			failed := test.fail != ""
+			if (err != nil) != failed {
+				t.Fatalf("migrate() = %v, want failed = %v", err, failed)
+			}
+
+			// the storage is only switched once every media is copied
+			want, previous, path := dst, src, dstPath
+			if failed {
+				want, previous, path = src, dst, srcPath
+			}
+			if config.Cfg.Media.Path != path {
+				t.Errorf("media path = %s, want %s", config.Cfg.Media.Path,
+					path)
+			}
+			for _, key := range []string{"migratez.png",
+				"migratea.png"} {
+				if _, err := want.Stat(key); err != nil {
+					t.Errorf("%s: %v", key, err)
+				}
+				_, err := previous.Stat(key)
+				if !failed && !errors.Is(err, storage.ErrNotFound) {
+					t.Errorf("%s left in the previous storage", key)
+				}
+			}
+			if m := GetMigration(); m.Running ||
+				(m.Error != "") != failed {
+				t.Errorf("migration = %+v", m)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"gorm.io/gorm"
+)
+
+// ModLog records a moderation action done by a staff member
+type ModLog struct {
+	gorm.Model
+	AccountID uint
+	Account   Account
+	BoardID   uint
+	Action    string
+	Details   string
+}
+
+func AddModLog(account Account, boardID uint, action string,
+	details string) error {
+	return db.Create(&ModLog{
+		AccountID: account.ID, BoardID: boardID,
+		Action: action, Details: details,
+	}).Error
+}
+
+func GetModLogs(limit int) ([]ModLog, error) {
+	var logs []ModLog
+	err := db.Preload("Account").Order("id desc").Limit(limit).
+		Find(&logs).Error
+	return logs, err
+}
@@ -0,0 +1,198 @@
+package db
+
+import (
+	"errors"
+	"html/template"
+	"regexp"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// Redirect keeps the old location of a moved thread reachable
+type Redirect struct {
+	gorm.Model
+	BoardID   int
+	Number    int
+	ToBoardID int
+	ToBoard   Board
+	ToNumber  int
+}
+
+func GetRedirect(board Board, number int) (Redirect, error) {
+	var redirect Redirect
+	err := db.Preload("ToBoard").First(&redirect,
+		"board_id = ? AND number = ?", board.ID, number).Error
+	return redirect, err
+}
+
+var quoteLink = regexp.MustCompile(
+	`<a class="l-([0-9]+)" href="#[0-9]+">&gt;&gt;[0-9]+</a>`)
+
+func renumberContent(content template.HTML,
+	numbers map[int]int) template.HTML {
+	return template.HTML(quoteLink.ReplaceAllStringFunc(string(content),
+		func(link string) string {
+			n, err := strconv.Atoi(quoteLink.FindStringSubmatch(link)[1])
+			if err != nil {
+				return link
+			}
+			number := strconv.Itoa(renumber(numbers, n))
+			return "<a class=\"l-" + number + "\" href=\"#" + number +
+				"\">&gt;&gt;" + number + "</a>"
+		}))
+}
+
+// transferPosts attaches the posts to the thread, renumbering the ones
+// coming from another board with the counter of the destination board.
+// It returns the new number of every transferred post.
+func transferPosts(tx *gorm.DB, posts []Post, thread Thread,
+	board Board) (map[int]int, error) {
+	if err := tx.Select("Posts").Find(&board).Error; err != nil {
+		return nil, err
+	}
+	numbers := map[int]int{}
+	for _, post := range posts {
+		numbers[post.Number] = post.Number
+		if post.BoardID != int(board.ID) {
+			board.Posts++
+			numbers[post.Number] = board.Posts
+		}
+	}
+	for _, post := range posts {
+		err := tx.Model(&Post{}).Where("id = ?", post.ID).Updates(
+			map[string]interface{}{
+				"thread_id": thread.ID,
+				"board_id":  board.ID,
+				"number":    numbers[post.Number],
+				"content": renumberContent(
+					post.Content, numbers),
+			}).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return numbers, tx.Model(&board).Update("Posts", board.Posts).Error
+}
+
+func renumber(numbers map[int]int, number int) int {
+	if v, ok := numbers[number]; ok {
+		return v
+	}
+	return number
+}
+
+func transferReferences(tx *gorm.DB, from uint, to uint,
+	numbers map[int]int) error {
+	var refs []Reference
+	if err := tx.Find(&refs, "thread_id = ?", from).Error; err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		err := tx.Model(&Reference{}).Where("id = ?", ref.ID).Updates(
+			map[string]interface{}{
+				"thread_id": to,
+				"from":      renumber(numbers, ref.From),
+				"post_id":   renumber(numbers, ref.PostID),
+			}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addRedirect(tx *gorm.DB, from Thread, board Board, number int) error {
+	err := tx.Model(&Redirect{}).
+		Where("to_board_id = ? AND to_number = ?",
+			from.BoardID, from.Number).
+		Updates(map[string]interface{}{
+			"to_board_id": board.ID, "to_number": number,
+		}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Create(&Redirect{
+		BoardID: from.BoardID, Number: from.Number,
+		ToBoardID: int(board.ID), ToNumber: number,
+	}).Error
+}
+
+func MoveThread(thread Thread, board Board) (int, error) {
+	if thread.BoardID == int(board.ID) {
+		return -1, errors.New("the thread is already on this board")
+	}
+	if dbType == TYPE_SQLITE {
+		newPostLock.Lock()
+	}
+	number := -1
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var posts []Post
+		err := tx.Order("number").
+			Find(&posts, "thread_id = ?", thread.ID).Error
+		if err != nil {
+			return err
+		}
+		numbers, err := transferPosts(tx, posts, thread, board)
+		if err != nil {
+			return err
+		}
+		err = transferReferences(tx, thread.ID, thread.ID, numbers)
+		if err != nil {
+			return err
+		}
+		number = numbers[thread.Number]
+		err = tx.Model(&Thread{}).Where("id = ?", thread.ID).Updates(
+			map[string]interface{}{
+				"board_id": board.ID, "number": number,
+			}).Error
+		if err != nil {
+			return err
+		}
+		return addRedirect(tx, thread, board, number)
+	})
+	if dbType == TYPE_SQLITE {
+		newPostLock.Unlock()
+	}
+	if err != nil {
+		return -1, err
+	}
+	// the moved thread keeps its bump order, so it could be the oldest
+	// one of a full board
+	return number, deleteThreads(board, thread.ID)
+}
+
+// MergeThreads moves every post of src into dst and removes src
+func MergeThreads(src Thread, dst Thread) error {
+	if src.ID == dst.ID {
+		return errors.New("cannot merge a thread into itself")
+	}
+	if dbType == TYPE_SQLITE {
+		newPostLock.Lock()
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var posts []Post
+		err := tx.Order("timestamp, number").
+			Find(&posts, "thread_id = ?", src.ID).Error
+		if err != nil {
+			return err
+		}
+		numbers, err := transferPosts(tx, posts, dst, dst.Board)
+		if err != nil {
+			return err
+		}
+		err = transferReferences(tx, src.ID, dst.ID, numbers)
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Delete(&Thread{}, src.ID).Error
+		if err != nil {
+			return err
+		}
+		return addRedirect(tx, src, dst.Board, dst.Number)
+	})
+	if dbType == TYPE_SQLITE {
+		newPostLock.Unlock()
+	}
+	return err
+}
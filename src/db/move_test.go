@@ -0,0 +1,65 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"IB1/config"
+)
+
+func TestMoveThreadToFullBoard(t *testing.T) {
+	previous := config.Cfg.Board.MaxThreads
+	config.Cfg.Board.MaxThreads = 2
+	defer func() { config.Cfg.Board.MaxThreads = previous }()
+	for _, name := range []string{"from", "to"} {
+		if err := CreateBoard(name, name, "", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := LoadBoards(); err != nil {
+		t.Fatal(err)
+	}
+
+	session := strings.Repeat("s", 32)
+	create := func(board string, title string, timestamp int64) Thread {
+		t.Helper()
+		number, _, err := CreateThread(Boards[board], title, "", "", "",
+			false, "127.0.0.1", session, Account{}, false, false, "text",
+			POST_VISIBLE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		thread, err := GetThread(Boards[board], number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.Model(&Post{}).Where("thread_id = ?", thread.ID).
+			Update("timestamp", timestamp).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		return thread
+	}
+	moved := create("from", "moved", 100)
+	create("to", "oldest", 200)
+	create("to", "newest", 300)
+
+	number, err := MoveThread(moved, Boards["to"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	board := Boards["to"]
+	if err := refreshBoard(&board, ^uint(0)); err != nil {
+		t.Fatal(err)
+	}
+	titles := []string{}
+	for _, v := range board.Threads {
+		titles = append(titles, v.Title)
+	}
+	if strings.Join(titles, " ") != "newest moved" {
+		t.Fatalf("threads of the board = %v, want [newest moved]", titles)
+	}
+	if _, err := GetThread(Boards["to"], number); err != nil {
+		t.Errorf("GetThread() of the moved thread = %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"gorm.io/gorm"
+
+	"IB1/config"
+)
+
+// NotificationEvent is something the staff can be notified about
+type NotificationEvent string
+
+const (
+	EVENT_PENDING_MEDIA  NotificationEvent = "pending-media"
+	EVENT_NEW_THREAD     NotificationEvent = "new-thread"
+	EVENT_LOGIN_FAILURES NotificationEvent = "login-failures"
+	EVENT_CERTIFICATE    NotificationEvent = "certificate-expiry"
+)
+
+func (e NotificationEvent) Description() string {
+	switch e {
+	case EVENT_PENDING_MEDIA:
+		return "Media pending approval"
+	case EVENT_NEW_THREAD:
+		return "New thread"
+	case EVENT_LOGIN_FAILURES:
+		return "Burst of failed logins"
+	case EVENT_CERTIFICATE:
+		return "SSL certificate expiring"
+	}
+	return "Unknown"
+}
+
+func GetNotificationEvents() []NotificationEvent {
+	return []NotificationEvent{
+		EVENT_PENDING_MEDIA, EVENT_NEW_THREAD, EVENT_LOGIN_FAILURES,
+		EVENT_CERTIFICATE,
+	}
+}
+
+// NotificationChannel is a service the notifications are sent to, URL,
+// Token and Target being interpreted according to its kind
+type NotificationChannel struct {
+	gorm.Model
+	CRUD[NotificationChannel]
+	Name     string `gorm:"unique"`
+	Kind     string
+	Disabled bool
+	URL      string
+	Token    string
+	// Target is the recipient of the emails or the Matrix room
+	Target string
+	Events []NotificationEvent `gorm:"serializer:json"`
+	// Boards restricts the notifications about posts to some boards,
+	// empty for all
+	Boards []uint `gorm:"serializer:json"`
+	// TitleTemplate and MessageTemplate format the notifications, the
+	// default title and message being used when empty
+	TitleTemplate   string
+	MessageTemplate string
+}
+
+func (n NotificationChannel) Subscribed(event NotificationEvent) bool {
+	for _, v := range n.Events {
+		if v == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (n NotificationChannel) AppliesTo(board Board) bool {
+	return Wordfilter{Boards: n.Boards}.AppliesTo(board)
+}
+
+func (n NotificationChannel) BoardNames() string {
+	return Wordfilter{Boards: n.Boards}.BoardNames()
+}
+
+func GetChannel(id int) (NotificationChannel, error) {
+	var channel NotificationChannel
+	err := db.First(&channel, id).Error
+	return channel, err
+}
+
+// GetChannels returns the enabled channels subscribed to an event
+func GetChannels(event NotificationEvent) ([]NotificationChannel, error) {
+	channels, err := NotificationChannel{}.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	subscribed := []NotificationChannel{}
+	for _, v := range channels {
+		if !v.Disabled && v.Subscribed(event) {
+			subscribed = append(subscribed, v)
+		}
+	}
+	return subscribed, nil
+}
+
+// migrateNotificationURL turns the notification URL of older
+// configurations into a ntfy channel
+func migrateNotificationURL() error {
+	if config.Cfg.Media.NotificationURL == "" {
+		return nil
+	}
+	err := db.Create(&NotificationChannel{
+		Name: "ntfy", Kind: "ntfy",
+		URL:    config.Cfg.Media.NotificationURL,
+		Events: []NotificationEvent{EVENT_PENDING_MEDIA},
+	}).Error
+	if err != nil {
+		return err
+	}
+	config.Cfg.Media.NotificationURL = ""
+	return UpdateConfig()
+}
@@ -8,6 +8,7 @@ import (
 	"gorm.io/gorm"
 	"hash/fnv"
 	"html/template"
+	"log"
 	"strconv"
 	"strings"
 	"sync"
@@ -32,29 +33,42 @@ func RandomID(in string) (string, error) {
 
 type Post struct {
 	gorm.Model
-	Content   template.HTML
-	Media     string
-	MediaHash string
-	From      string
-	Name      string
-	ThreadID  int
-	Thread    Thread
-	BoardID   int
-	Board     Board
-	Number    int
-	Timestamp int64
-	IP        string
-	Disabled  bool
-	OwnerID   uint
-	Owner     Account
-	Session   string `gorm:"size:32"`
-	Signed    bool
-	Sage      bool
-	Rank      string
-	Country   string
-	RandomID  string
+	Content    template.HTML
+	Media      string
+	MediaHash  string
+	Filename   string
+	From       string
+	Name       string
+	ThreadID   int
+	Thread     Thread
+	BoardID    int
+	Board      Board
+	Number     int
+	Timestamp  int64
+	IP         string
+	Disabled   bool
+	OwnerID    uint
+	Owner      Account
+	Session    string `gorm:"size:32"`
+	Signed     bool
+	Sage       bool
+	Rank       string
+	Country    string
+	RandomID   string
+	Annotation string
+	Pending    bool
+	Processing bool
 }
 
+// PostState is the visibility of a post when it is created
+type PostState int
+
+const (
+	POST_VISIBLE PostState = iota
+	POST_HIDDEN
+	POST_PENDING
+)
+
 type Reference struct {
 	gorm.Model
 	From     int
@@ -126,6 +140,15 @@ func (post Post) Thumbnail() string {
 	return post.Media[0:i] + ".png"
 }
 
+// MediaName returns the original name of the media of the post if it was
+// kept or the name it is stored under
+func (post Post) MediaName() string {
+	if post.Filename != "" {
+		return post.Filename
+	}
+	return post.Media
+}
+
 func (post Post) ReferredBy() []Reference {
 	var refs []Reference
 	err := db.Where("thread_id = ? AND post_id = ?",
@@ -139,11 +162,11 @@ func (post Post) ReferredBy() []Reference {
 var newPostLock sync.Mutex
 
 func CreatePost(thread Thread, content template.HTML, name string,
-	media string, ip string, session string, account Account,
-	signed bool, rank bool, sage bool,
-	custom *gorm.DB) (int, error) {
+	media string, filename string, processing bool, ip string, session string,
+	account Account, signed bool, rank bool, sage bool, state PostState,
+	custom *gorm.DB) (int, uint, error) {
 	if len(session) < 32 || len(session) > 64 {
-		return -1, errors.New("invalid session")
+		return -1, 0, errors.New("invalid session")
 	}
 	if custom == nil {
 		custom = db
@@ -155,6 +178,7 @@ func CreatePost(thread Thread, content template.HTML, name string,
 		newPostLock.Lock()
 	}
 	number := -1
+	var id uint
 	err := custom.Transaction(func(tx *gorm.DB) error {
 
 		tx.Select("Posts").Find(&thread.Board)
@@ -183,27 +207,71 @@ func CreatePost(thread Thread, content template.HTML, name string,
 			}
 		}
 
-		ret := tx.Create(&Post{
+		post := Post{
 			Board: thread.Board, Thread: thread, Name: name,
 			Content: content, Timestamp: time.Now().Unix(),
-			Number: thread.Board.Posts, Media: media,
+			Number: thread.Board.Posts, Media: media, Filename: filename,
 			MediaHash: strings.Split(media, ".")[0],
 			Session:   session, OwnerID: account.ID,
 			IP: ip, Signed: signed, Rank: rankValue.Name,
 			Country: country, RandomID: randomID, Sage: sage,
-		})
-		if ret.Error != nil {
+			Disabled: state != POST_VISIBLE,
+			Pending:  state == POST_PENDING, Processing: processing,
+		}
+		if err := tx.Create(&post).Error; err != nil {
 			return err
 		}
 
 		number = thread.Board.Posts
+		id = post.ID
 
 		return nil
 	})
 	if dbType == TYPE_SQLITE {
 		newPostLock.Unlock()
 	}
-	return number, err
+	return number, id, err
+}
+
+// AttachMedia sets the media of a post once processed, the media being
+// removed if the post was deleted meanwhile
+func AttachMedia(id uint, media string) error {
+	hash := strings.Split(media, ".")[0]
+	res := db.Model(&Post{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"media": media, "media_hash": hash, "processing": false,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+	log.Println("post", id, "deleted while its media was processed")
+	var count int64
+	err := db.Model(&Post{}).Where("media_hash = ?", hash).
+		Count(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+	return RemoveMedia(hash)
+}
+
+// DetachMedia removes the media of a post whose processing failed
+func DetachMedia(id uint) error {
+	return db.Model(&Post{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"filename": "", "processing": false,
+		}).Error
+}
+
+// ClearProcessing removes the media of the posts left processing when the
+// server stopped
+func ClearProcessing() error {
+	return db.Model(&Post{}).Where("processing = ?", true).
+		Updates(map[string]interface{}{
+			"filename": "", "processing": false,
+		}).Error
 }
 
 func GetPost(threadID uint, number int) (Post, error) {
@@ -233,11 +301,85 @@ func GetPostFromBoard(board string, number int) (Post, error) {
 	return post, err
 }
 
+var postFields = map[string]string{
+	"ip":      "ip",
+	"session": "session",
+	"id":      "random_id",
+}
+
+// GetPostsBy returns the posts sharing the ip, session or poster id of
+// the given post, on its board or on every board when global is set
+func GetPostsBy(post Post, field string, global bool) ([]Post, error) {
+	column, ok := postFields[field]
+	if !ok {
+		return nil, errors.New("invalid field")
+	}
+	value := map[string]string{
+		"ip": post.IP, "session": post.Session, "id": post.RandomID,
+	}[field]
+	if value == "" {
+		return nil, errors.New("the post has no " + field)
+	}
+	tx := db.Preload("Board").Preload("Thread").
+		Where(column+" = ?", value)
+	if !global {
+		tx = tx.Where("board_id = ?", post.BoardID)
+	}
+	var posts []Post
+	err := tx.Order("board_id, number").Find(&posts).Error
+	return posts, err
+}
+
 func CreateReference(thread uint, from int, to int) error {
 	ref := Reference{ThreadID: int(thread), PostID: to, From: from}
 	return db.Create(&ref).Error
 }
 
+func EachPost(boards []uint, f func(Post)) error {
+	var posts []Post
+	tx := db.Select("id", "board_id", "name", "content")
+	if len(boards) > 0 {
+		tx = tx.Where("board_id IN ?", boards)
+	}
+	return tx.FindInBatches(&posts, 500, func(*gorm.DB, int) error {
+		for _, post := range posts {
+			f(post)
+		}
+		return nil
+	}).Error
+}
+
+func CountSessionPosts(session string) (int64, error) {
+	var count int64
+	err := db.Model(&Post{}).Where("session = ?", session).
+		Count(&count).Error
+	return count, err
+}
+
+func GetPendingPosts() ([]Post, error) {
+	var posts []Post
+	err := db.Preload("Board").Preload("Thread").Order("id").
+		Find(&posts, "pending = ?", true).Error
+	return posts, err
+}
+
+func GetPendingPost(id uint) (Post, error) {
+	var post Post
+	err := db.Preload("Board").Preload("Thread").
+		First(&post, "id = ? AND pending = ?", id, true).Error
+	return post, err
+}
+
+func ApprovePost(id uint) error {
+	return db.Model(&Post{}).Where("id = ?", id).Updates(
+		map[string]interface{}{"disabled": false, "pending": false}).Error
+}
+
+func Annotate(id uint, annotation string) error {
+	return db.Model(&Post{}).Where("id = ?", id).
+		Update("Annotation", annotation).Error
+}
+
 func Hide(id uint, reverse bool) error {
 	return db.Model(&Post{}).Where("id = ?", id).
 		Update("Disabled", !reverse).Error
@@ -62,6 +62,7 @@ const (
 	CREATE_THREAD
 	PIN_THREAD
 	TOGGLE_SPOILER
+	MOVE_THREAD
 	LAST
 )
 
@@ -32,12 +32,13 @@ func _() {
 	_ = x[CREATE_THREAD-21]
 	_ = x[PIN_THREAD-22]
 	_ = x[TOGGLE_SPOILER-23]
-	_ = x[LAST-24]
+	_ = x[MOVE_THREAD-24]
+	_ = x[LAST-25]
 }
 
-const _Privilege_name = "NONECREATE_BOARDADMINISTRATIONMANAGE_USERBAN_USERAPPROVE_MEDIABAN_MEDIAREMOVE_MEDIAREMOVE_POSTHIDE_POSTBYPASS_CAPTCHABYPASS_MEDIA_APPROVALVIEW_HIDDENVIEW_PENDING_MEDIAVIEW_IPBAN_IPSHOW_RANKBYPASS_READONLYVIEW_PRIVATEUSE_PRIVATECREATE_POSTCREATE_THREADPIN_THREADTOGGLE_SPOILERLAST"
+const _Privilege_name = "NONECREATE_BOARDADMINISTRATIONMANAGE_USERBAN_USERAPPROVE_MEDIABAN_MEDIAREMOVE_MEDIAREMOVE_POSTHIDE_POSTBYPASS_CAPTCHABYPASS_MEDIA_APPROVALVIEW_HIDDENVIEW_PENDING_MEDIAVIEW_IPBAN_IPSHOW_RANKBYPASS_READONLYVIEW_PRIVATEUSE_PRIVATECREATE_POSTCREATE_THREADPIN_THREADTOGGLE_SPOILERMOVE_THREADLAST"
 
-var _Privilege_index = [...]uint16{0, 4, 16, 30, 41, 49, 62, 71, 83, 94, 103, 117, 138, 149, 167, 174, 180, 189, 204, 216, 227, 238, 251, 261, 275, 279}
+var _Privilege_index = [...]uint16{0, 4, 16, 30, 41, 49, 62, 71, 83, 94, 103, 117, 138, 149, 167, 174, 180, 189, 204, 216, 227, 238, 251, 261, 275, 286, 290}
 
 func (i Privilege) String() string {
 	if i < 0 || i >= Privilege(len(_Privilege_index)-1) {
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SpamToken counts how many spam and legitimate posts contained a token
+type SpamToken struct {
+	gorm.Model
+	Token string `gorm:"uniqueIndex;size:64"`
+	Spam  int
+	Ham   int
+}
+
+// TrainTokens adds spam and ham to the counts of tokens, which must be
+// unique
+func TrainTokens(tokens []string, spam int, ham int) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	list := make([]SpamToken, len(tokens))
+	for i, token := range tokens {
+		list[i] = SpamToken{
+			Token: token, Spam: max(spam, 0), Ham: max(ham, 0),
+		}
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "token"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"spam": gorm.Expr("spam_tokens.spam + ?", spam),
+			"ham":  gorm.Expr("spam_tokens.ham + ?", ham),
+		}),
+	}).CreateInBatches(&list, 100).Error
+}
+
+func GetSpamTokens(tokens []string) (map[string]SpamToken, error) {
+	var list []SpamToken
+	err := db.Where("token IN ?", tokens).Find(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]SpamToken{}
+	for _, v := range list {
+		m[v.Token] = v
+	}
+	return m, nil
+}
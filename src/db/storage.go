@@ -0,0 +1,137 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path"
+	"strings"
+
+	"IB1/storage"
+)
+
+// databaseStorage keeps the files inside the data and thumbnail columns
+// of the media table, the media having to be added before its files
+type databaseStorage struct{}
+
+func init() {
+	storage.Register("database", func(storage.Target) (storage.Storage,
+		error) {
+		return databaseStorage{}, nil
+	})
+}
+
+// column returns the hash and the column of the media a key refers to
+func (databaseStorage) column(key string) (string, string, error) {
+	column := "data"
+	if strings.HasPrefix(key, "thumbnail/reply/") {
+		key = strings.TrimPrefix(key, "thumbnail/reply/")
+		column = "reply_thumbnail"
+	} else if strings.HasPrefix(key, "thumbnail/") {
+		key = strings.TrimPrefix(key, "thumbnail/")
+		column = "thumbnail"
+	}
+	hash := strings.Split(key, ".")[0]
+	if hash == "" || strings.Contains(hash, "/") {
+		return "", "", errors.New("invalid file name")
+	}
+	return hash, column, nil
+}
+
+func (s databaseStorage) Put(key string, r io.Reader) error {
+	hash, column, err := s.column(key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	res := db.Model(&Media{}).Where("hash = ?", hash).Update(column, data)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s databaseStorage) data(key string) ([]byte, error) {
+	hash, column, err := s.column(key)
+	if err != nil {
+		return nil, err
+	}
+	var media Media
+	err = db.Select(column).First(&media, "hash = ?", hash).Error
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+	data := media.Data
+	switch column {
+	case "thumbnail":
+		data = media.Thumbnail
+	case "reply_thumbnail":
+		data = media.ReplyThumbnail
+	}
+	if len(data) == 0 {
+		return nil, storage.ErrNotFound
+	}
+	return data, nil
+}
+
+func (s databaseStorage) Get(key string) (io.ReadCloser, error) {
+	data, err := s.data(key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s databaseStorage) Delete(key string) error {
+	hash, column, err := s.column(key)
+	if err != nil {
+		return err
+	}
+	// the thumbnails share a column whatever their extension, so a
+	// thumbnail with another extension than the current one was already
+	// replaced by it
+	if column != "data" {
+		media, err := GetMedia(hash)
+		if err == nil && path.Ext(key) != media.thumbnailExtension() {
+			return nil
+		}
+	}
+	return db.Model(&Media{}).Where("hash = ?", hash).
+		Update(column, nil).Error
+}
+
+func (s databaseStorage) Stat(key string) (storage.Info, error) {
+	hash, column, err := s.column(key)
+	if err != nil {
+		return storage.Info{}, err
+	}
+	var size *int64
+	err = db.Model(&Media{}).Select("LENGTH("+column+")").
+		Where("hash = ?", hash).Row().Scan(&size)
+	if err != nil || size == nil || *size == 0 {
+		return storage.Info{}, storage.ErrNotFound
+	}
+	return storage.Info{Size: *size}, nil
+}
+
+func (s databaseStorage) Range(key string, offset int64,
+	length int64) (io.ReadCloser, error) {
+	data, err := s.data(key)
+	if err != nil {
+		return nil, err
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
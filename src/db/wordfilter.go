@@ -1,15 +1,79 @@
 package db
 
 import (
+	"errors"
 	"gorm.io/gorm"
 	"regexp"
+	"sort"
+	"strings"
 )
 
+type WordfilterAction int
+
+const (
+	WORDFILTER_REPLACE WordfilterAction = iota
+	WORDFILTER_REJECT
+	WORDFILTER_PENDING
+	WORDFILTER_BAN
+)
+
+func (a WordfilterAction) String() string {
+	switch a {
+	case WORDFILTER_REPLACE:
+		return "Replace"
+	case WORDFILTER_REJECT:
+		return "Reject post"
+	case WORDFILTER_PENDING:
+		return "Hide pending review"
+	case WORDFILTER_BAN:
+		return "Reject and ban"
+	}
+	return "Unknown"
+}
+
 type Wordfilter struct {
 	gorm.Model
 	CRUD[Wordfilter]
-	From     string `gorm:"unique"`
-	To       string
-	Disabled bool
-	Regexp   *regexp.Regexp `gorm:"-:all"`
+	From        string `gorm:"unique"`
+	To          string
+	Disabled    bool
+	Action      WordfilterAction
+	BanDuration int64
+	Boards      []uint         `gorm:"serializer:json"`
+	Regexp      *regexp.Regexp `gorm:"-:all"`
+}
+
+func (w Wordfilter) AppliesTo(board Board) bool {
+	if len(w.Boards) == 0 {
+		return true
+	}
+	for _, v := range w.Boards {
+		if v == board.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (w Wordfilter) BoardNames() string {
+	names := []string{}
+	for _, board := range Boards {
+		if w.AppliesTo(board) && len(w.Boards) > 0 {
+			names = append(names, board.Name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+func GetBoardIDs(names string) ([]uint, error) {
+	ids := []uint{}
+	for _, name := range strings.Fields(names) {
+		board, ok := Boards[name]
+		if !ok {
+			return nil, errors.New("board not found: " + name)
+		}
+		ids = append(ids, board.ID)
+	}
+	return ids, nil
 }
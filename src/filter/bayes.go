@@ -0,0 +1,126 @@
+package filter
+
+import (
+	"html"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"IB1/config"
+	"IB1/db"
+)
+
+// minimum number of spam and legitimate posts learned before the
+// bayesian scorer is used
+const minTraining = 10
+
+// number of tokens with the strongest probability used for a post
+const interestingTokens = 15
+
+// the empty token counts the number of posts learned
+const documentToken = ""
+
+var tagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+func Tokenize(text string) []string {
+	text = html.UnescapeString(tagRegexp.ReplaceAllString(text, " "))
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) &&
+			r != '.' && r != '-' && r != '\''
+	})
+	seen := map[string]bool{}
+	tokens := []string{}
+	for _, v := range fields {
+		v = strings.Trim(v, ".-'")
+		if len(v) < 3 || len(v) > 32 || seen[v] {
+			continue
+		}
+		seen[v] = true
+		tokens = append(tokens, v)
+		if len(tokens) >= 200 {
+			break
+		}
+	}
+	return tokens
+}
+
+func learn(content string, spam int, ham int) error {
+	if !config.Cfg.Spam.Enabled {
+		return nil
+	}
+	return db.TrainTokens(append(Tokenize(content), documentToken),
+		spam, ham)
+}
+
+// LearnHam trains the bayesian scorer with a legitimate post
+func LearnHam(content string) error {
+	return learn(content, 0, 1)
+}
+
+// LearnSpam trains the bayesian scorer with a post removed by the staff,
+// visible posts having already been learned as legitimate
+func LearnSpam(post db.Post) error {
+	ham := 0
+	if !post.Disabled && !post.Pending {
+		ham = -1
+	}
+	return learn(string(post.Content), 1, ham)
+}
+
+type bayesScorer struct{}
+
+func (bayesScorer) Score(post Candidate) (float64, error) {
+	weight := config.Cfg.Spam.BayesScore
+	if weight == 0 {
+		return 0, nil
+	}
+	tokens := Tokenize(post.Title + " " + post.Content)
+	if len(tokens) == 0 {
+		return 0, nil
+	}
+	counts, err := db.GetSpamTokens(append(tokens, documentToken))
+	if err != nil {
+		return 0, err
+	}
+	total := counts[documentToken]
+	if total.Spam < minTraining || total.Ham < minTraining {
+		return 0, nil
+	}
+	probabilities := []float64{}
+	for _, token := range tokens {
+		v, ok := counts[token]
+		if !ok {
+			continue
+		}
+		spam := float64(max(v.Spam, 0)) / float64(total.Spam)
+		ham := float64(max(v.Ham, 0)) / float64(total.Ham)
+		if spam+ham == 0 {
+			continue
+		}
+		n := float64(max(v.Spam, 0) + max(v.Ham, 0))
+		p := (0.5 + n*spam/(spam+ham)) / (1 + n)
+		probabilities = append(probabilities, p)
+	}
+	if len(probabilities) == 0 {
+		return 0, nil
+	}
+	sort.Slice(probabilities, func(i, j int) bool {
+		return math.Abs(probabilities[i]-0.5) >
+			math.Abs(probabilities[j]-0.5)
+	})
+	if len(probabilities) > interestingTokens {
+		probabilities = probabilities[:interestingTokens]
+	}
+	spam, ham := 0.0, 0.0
+	for _, p := range probabilities {
+		spam += math.Log(p)
+		ham += math.Log(1 - p)
+	}
+	probability := 1 / (1 + math.Exp(ham-spam))
+	if probability <= 0.5 {
+		return 0, nil
+	}
+	return (probability*2 - 1) * weight, nil
+}
@@ -0,0 +1,175 @@
+package filter
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"IB1/config"
+	"IB1/db"
+)
+
+var ErrSpam = errors.New("your post was rejected as spam")
+
+// Candidate is a post about to be created
+type Candidate struct {
+	Title   string
+	Name    string
+	Content string
+	Session string
+	IP      string
+}
+
+func (post Candidate) text() string {
+	return post.Title + "\n" + post.Name + "\n" + post.Content
+}
+
+// Scorer rates how likely a post is to be spam, 0 meaning that nothing
+// suspicious was found
+type Scorer interface {
+	Score(post Candidate) (float64, error)
+}
+
+var Scorers = []Scorer{
+	linkScorer{},
+	repeatScorer{},
+	capsScorer{},
+	domainScorer{},
+	sessionScorer{},
+	bayesScorer{},
+}
+
+func Score(post Candidate) (float64, error) {
+	total := 0.0
+	for _, scorer := range Scorers {
+		v, err := scorer.Score(post)
+		if err != nil {
+			return 0, err
+		}
+		total += v
+	}
+	return total, nil
+}
+
+// Check returns the state a post should be created with, or ErrSpam if
+// the post must be rejected
+func Check(post Candidate) (db.PostState, error) {
+	spam := config.Cfg.Spam
+	if !spam.Enabled {
+		return db.POST_VISIBLE, nil
+	}
+	score, err := Score(post)
+	if err != nil {
+		return db.POST_VISIBLE, err
+	}
+	if spam.Reject > 0 && score >= spam.Reject {
+		return db.POST_VISIBLE, ErrSpam
+	}
+	if spam.Queue > 0 && score >= spam.Queue {
+		return db.POST_PENDING, nil
+	}
+	if spam.Hide > 0 && score >= spam.Hide {
+		return db.POST_HIDDEN, nil
+	}
+	return db.POST_VISIBLE, nil
+}
+
+var linkRegexp = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+type linkScorer struct{}
+
+func (linkScorer) Score(post Candidate) (float64, error) {
+	links := len(linkRegexp.FindAllString(post.text(), -1))
+	if links <= config.Cfg.Spam.MaxLinks {
+		return 0, nil
+	}
+	return float64(links-config.Cfg.Spam.MaxLinks) *
+		config.Cfg.Spam.LinkScore, nil
+}
+
+type repeatScorer struct{}
+
+func (repeatScorer) Score(post Candidate) (float64, error) {
+	words := map[string]bool{}
+	total := 0
+	for _, word := range strings.Fields(strings.ToLower(post.Content)) {
+		if len(word) < 3 {
+			continue
+		}
+		words[word] = true
+		total++
+	}
+	if total < 8 {
+		return 0, nil
+	}
+	repetition := 1 - float64(len(words))/float64(total)
+	if repetition < 0.5 {
+		return 0, nil
+	}
+	return repetition * config.Cfg.Spam.RepeatScore, nil
+}
+
+type capsScorer struct{}
+
+func (capsScorer) Score(post Candidate) (float64, error) {
+	letters := 0
+	upper := 0
+	for _, r := range post.Title + post.Content {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+	if letters < 16 ||
+		float64(upper)/float64(letters) < config.Cfg.Spam.CapsRatio {
+		return 0, nil
+	}
+	return config.Cfg.Spam.CapsScore, nil
+}
+
+var domainRegexp = regexp.MustCompile(`(?i)(?:[a-z0-9-]+\.)+[a-z]{2,}`)
+
+type domainScorer struct{}
+
+func (domainScorer) Score(post Candidate) (float64, error) {
+	if len(config.Cfg.Spam.BadDomains) == 0 {
+		return 0, nil
+	}
+	hosts := map[string]bool{}
+	for _, host := range domainRegexp.FindAllString(post.text(), -1) {
+		hosts[strings.ToLower(host)] = true
+	}
+	score := 0.0
+	for host := range hosts {
+		for _, domain := range config.Cfg.Spam.BadDomains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				score += config.Cfg.Spam.DomainScore
+				break
+			}
+		}
+	}
+	return score, nil
+}
+
+type sessionScorer struct{}
+
+func (sessionScorer) Score(post Candidate) (float64, error) {
+	if config.Cfg.Spam.NewSessionScore == 0 {
+		return 0, nil
+	}
+	if post.Session == "" {
+		return config.Cfg.Spam.NewSessionScore, nil
+	}
+	count, err := db.CountSessionPosts(post.Session)
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, nil
+	}
+	return config.Cfg.Spam.NewSessionScore, nil
+}
@@ -1,7 +1,11 @@
 package filter
 
 import (
+	"errors"
+	"html"
+	"log"
 	"regexp"
+	"regexp/syntax"
 
 	"IB1/db"
 	"IB1/util"
@@ -11,6 +15,36 @@ var Wordfilters = util.SafeObj[[]db.Wordfilter]{
 	Reload: getWordfilters,
 }
 
+const maxPatternLength = 512
+const maxPatternInstructions = 4096
+
+// Compile parses a wordfilter pattern, refusing the ones matching empty
+// text or too expensive to run on every post
+func Compile(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > maxPatternLength {
+		return nil, errors.New("the pattern is too long")
+	}
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return nil, err
+	}
+	if len(prog.Inst) > maxPatternInstructions {
+		return nil, errors.New("the pattern is too complex")
+	}
+	v, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if v.MatchString("") {
+		return nil, errors.New("the pattern matches empty text")
+	}
+	return v, nil
+}
+
 func getWordfilters() ([]db.Wordfilter, error) {
 	v, err := db.Wordfilter{}.GetAll()
 	if err != nil {
@@ -18,21 +52,125 @@ func getWordfilters() ([]db.Wordfilter, error) {
 	}
 	filters := []db.Wordfilter{}
 	for _, v := range v {
-		if !v.Disabled {
-			v.Regexp = regexp.MustCompile(v.From)
-			filters = append(filters, v)
+		if v.Disabled {
+			continue
+		}
+		v.Regexp, err = Compile(v.From)
+		if err != nil {
+			log.Println("wordfilter", v.ID, "ignored:", err)
+			continue
 		}
+		filters = append(filters, v)
 	}
 	return filters, nil
 }
 
-func FilterText(in string) (string, error) {
+// Result is the strongest action triggered by the wordfilters on a post
+type Result struct {
+	Reject   bool
+	Pending  bool
+	Ban      int64
+	BanBoard uint
+}
+
+// FilterPost applies the wordfilters of the board to every text of a
+// post, replacing the matches in place
+func FilterPost(board db.Board, texts ...*string) (Result, error) {
+	filters, err := Wordfilters.Get()
+	if err != nil {
+		return Result{}, err
+	}
+	return apply(boardFilters(filters, board), board, texts...), nil
+}
+
+func boardFilters(filters []db.Wordfilter, board db.Board) []db.Wordfilter {
+	list := []db.Wordfilter{}
+	for _, filter := range filters {
+		if filter.AppliesTo(board) {
+			list = append(list, filter)
+		}
+	}
+	return list
+}
+
+func apply(filters []db.Wordfilter, board db.Board,
+	texts ...*string) Result {
+	var result Result
+	for _, filter := range filters {
+		for _, text := range texts {
+			if filter.Action == db.WORDFILTER_REPLACE {
+				*text = filter.Regexp.ReplaceAllString(
+					*text, filter.To)
+				continue
+			}
+			if !filter.Regexp.MatchString(*text) {
+				continue
+			}
+			switch filter.Action {
+			case db.WORDFILTER_REJECT:
+				result.Reject = true
+			case db.WORDFILTER_PENDING:
+				result.Pending = true
+			case db.WORDFILTER_BAN:
+				result.Reject = true
+				if filter.BanDuration <= result.Ban {
+					break
+				}
+				result.Ban = filter.BanDuration
+				result.BanBoard = 0
+				if len(filter.Boards) > 0 {
+					result.BanBoard = board.ID
+				}
+			}
+		}
+	}
+	return result
+}
+
+// Match is a wordfilter matching a sample text
+type Match struct {
+	Filter db.Wordfilter
+	Count  int
+}
+
+// Test runs the wordfilters on a sample text, ignoring the board scope
+// of the filters when board is nil
+func Test(text string, board *db.Board) ([]Match, string, Result, error) {
 	filters, err := Wordfilters.Get()
 	if err != nil {
-		return "", err
+		return nil, "", Result{}, err
 	}
+	scope := db.Board{}
+	if board != nil {
+		filters = boardFilters(filters, *board)
+		scope = *board
+	}
+	matches := []Match{}
 	for _, filter := range filters {
-		in = filter.Regexp.ReplaceAllString(in, filter.To)
+		n := len(filter.Regexp.FindAllStringIndex(text, -1))
+		if n > 0 {
+			matches = append(matches, Match{filter, n})
+		}
 	}
-	return in, nil
+	result := apply(filters, scope, &text)
+	return matches, text, result, nil
+}
+
+// DryRun counts the existing posts that a pattern would have matched
+func DryRun(pattern string, boards []uint) (int, int, error) {
+	re, err := Compile(pattern)
+	if err != nil {
+		return 0, 0, err
+	}
+	matched := 0
+	total := 0
+	err = db.EachPost(boards, func(post db.Post) {
+		total++
+		if re.MatchString(post.Name) ||
+			re.MatchString(html.UnescapeString(
+				tagRegexp.ReplaceAllString(string(post.Content), ""))) {
+			matched++
+		}
+	})
+	return matched, total, err
 }
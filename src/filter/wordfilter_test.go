@@ -0,0 +1,123 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+
+	"IB1/db"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		pattern string
+		valid   bool
+	}{
+		{"spam", true},
+		{`(?i)\bfree (money|coins)\b`, true},
+		{"[", false},
+		{"a*", false},
+		{"^", false},
+		{"(a|)", false},
+		{strings.Repeat("a", maxPatternLength), true},
+		{strings.Repeat("a", maxPatternLength+1), false},
+		{"(a{1,100}){1,100}", false},
+	}
+	for _, test := range tests {
+		re, err := Compile(test.pattern)
+		if (err == nil) != test.valid {
+			t.Errorf("Compile(%.20q) = %v, want valid = %v", test.pattern,
+				err, test.valid)
+		}
+		if err == nil && re == nil {
+			t.Errorf("Compile(%.20q) returned no regexp", test.pattern)
+		}
+	}
+}
+
+func setWordfilters(t *testing.T, filters ...db.Wordfilter) {
+	t.Helper()
+	for i := range filters {
+		re, err := Compile(filters[i].From)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filters[i].Regexp = re
+	}
+	Wordfilters.Reload = func() ([]db.Wordfilter, error) {
+		return filters, nil
+	}
+	Wordfilters.Refresh()
+	t.Cleanup(func() {
+		Wordfilters.Reload = getWordfilters
+		Wordfilters.Refresh()
+	})
+}
+
+func TestTest(t *testing.T) {
+	setWordfilters(t,
+		db.Wordfilter{From: "foo", To: "bar",
+			Action: db.WORDFILTER_REPLACE},
+		db.Wordfilter{From: "spam", Action: db.WORDFILTER_REJECT},
+		db.Wordfilter{From: "link", Action: db.WORDFILTER_PENDING},
+		db.Wordfilter{From: "scam", Action: db.WORDFILTER_BAN,
+			BanDuration: 3600},
+		db.Wordfilter{From: "fraud", Action: db.WORDFILTER_BAN,
+			BanDuration: 86400, Boards: []uint{2}},
+	)
+	board := db.Board{}
+	board.ID = 2
+	other := db.Board{}
+	other.ID = 3
+
+	tests := []struct {
+		name    string
+		text    string
+		board   *db.Board
+		matches int
+		want    string
+		result  Result
+	}{
+		{"no match", "hello", nil, 0, "hello", Result{}},
+		{"replace", "foo foo", nil, 1, "bar bar", Result{}},
+		{"reject", "spam", nil, 1, "spam", Result{Reject: true}},
+		{"pending", "a link", nil, 1, "a link", Result{Pending: true}},
+		{"global ban", "scam", &other, 1, "scam",
+			Result{Reject: true, Ban: 3600}},
+		{"board ban", "scam fraud", &board, 2, "scam fraud",
+			Result{Reject: true, Ban: 86400, BanBoard: 2}},
+		{"other board", "fraud", &other, 0, "fraud", Result{}},
+		{"any board", "fraud", nil, 1, "fraud",
+			Result{Reject: true, Ban: 86400}},
+		{"combined", "foo spam link", nil, 3, "bar spam link",
+			Result{Reject: true, Pending: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matches, text, result, err := Test(test.text, test.board)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(matches) != test.matches {
+				t.Errorf("%d matches, want %d", len(matches), test.matches)
+			}
+			if text != test.want {
+				t.Errorf("text = %q, want %q", text, test.want)
+			}
+			if result != test.result {
+				t.Errorf("result = %+v, want %+v", result, test.result)
+			}
+		})
+	}
+}
+
+func TestTestCount(t *testing.T) {
+	setWordfilters(t, db.Wordfilter{From: "(?i)ab",
+		Action: db.WORDFILTER_REJECT})
+	matches, _, _, err := Test("ab AB aB xab", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].Count != 4 {
+		t.Fatalf("matches = %+v, want one filter matching 4 times", matches)
+	}
+}
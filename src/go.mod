@@ -14,8 +14,10 @@ require (
 	github.com/h2non/bimg v1.1.9
 	github.com/labstack/echo/v4 v4.13.4
 	github.com/tdewolff/minify/v2 v2.23.11
+	github.com/wagslane/go-password-validator v0.3.0
 	github.com/yl2chen/cidranger v1.0.2
 	golang.org/x/crypto v0.41.0
+	golang.org/x/image v0.30.0
 	golang.org/x/term v0.34.0
 	gorm.io/driver/mysql v1.6.0
 	gorm.io/driver/sqlite v1.6.0
@@ -43,9 +45,7 @@ require (
 	github.com/tdewolff/parse/v2 v2.8.2-0.20250820182932-7692dd6e0943 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
-	github.com/wagslane/go-password-validator v0.3.0 // indirect
 	golang.org/x/exp v0.0.0-20250819193227-8b4c13bb791b // indirect
-	golang.org/x/image v0.30.0 // indirect
 	golang.org/x/mod v0.27.0 // indirect
 	golang.org/x/net v0.43.0 // indirect
 	golang.org/x/sync v0.16.0 // indirect
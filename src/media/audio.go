@@ -0,0 +1,84 @@
+package media
+
+import (
+	"errors"
+	"mime"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+var audioTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".ogg":  "audio/ogg",
+	".flac": "audio/flac",
+	".wav":  "audio/wav",
+}
+
+func init() {
+	for extension, mimeType := range audioTypes {
+		mime.AddExtensionType(extension, mimeType)
+	}
+}
+
+var errInvalidAudio = errors.New("invalid audio file")
+
+func probeDuration(in string) (float64, error) {
+	c := exec.Command("ffprobe", "-v", "error",
+		"-select_streams", "a:0", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", in)
+	out, err := c.Output()
+	if err != nil {
+		return 0, errInvalidAudio
+	}
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil || duration <= 0 {
+		return 0, errInvalidAudio
+	}
+	return duration, nil
+}
+
+// cleanAudio copies the audio stream without the metadata and the
+// embedded pictures
+func cleanAudio(in string, out string) error {
+	c := exec.Command("ffmpeg", "-i", in, "-map", "0:a:0",
+		"-map_metadata", "-1", "-fflags", "+bitexact", "-c:a", "copy",
+		out)
+	c.Stderr = os.Stderr
+	c.Stdout = nil
+	if err := c.Run(); err != nil {
+		return errInvalidAudio
+	}
+	return nil
+}
+
+// extractCover saves the cover art embedded in an audio file
+func extractCover(in string, out string) error {
+	c := exec.Command("ffmpeg", "-i", in, "-an", "-map", "0:v:0",
+		"-frames:v", "1", out)
+	c.Stderr = nil
+	c.Stdout = nil
+	c.Run()
+	_, err := os.Stat(out)
+	return err
+}
+
+func waveform(in string, out string) error {
+	c := exec.Command("ffmpeg", "-i", in, "-filter_complex",
+		"showwavespic=s=400x200:colors=#7f7f7f", "-frames:v", "1", out)
+	c.Stderr = os.Stderr
+	c.Stdout = nil
+	c.Run()
+	_, err := os.Stat(out)
+	return err
+}
+
+// audioPreview saves the image used as thumbnail of an audio file, its
+// cover art or a waveform when it has none
+func audioPreview(in string, out string) error {
+	if err := extractCover(in, out); err == nil {
+		return nil
+	}
+	return waveform(in, out)
+}
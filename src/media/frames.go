@@ -0,0 +1,78 @@
+package media
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"IB1/config"
+	"IB1/db"
+)
+
+// extractFrames saves a frame every FrameInterval seconds, up to MaxFrames,
+// returning the paths of the frames
+func extractFrames(in string, prefix string) ([]string, error) {
+	interval := config.Cfg.Media.FrameInterval
+	count := config.Cfg.Media.MaxFrames
+	if interval <= 0 || count <= 0 {
+		return nil, nil
+	}
+	c := exec.Command("ffmpeg", "-i", in,
+		"-vf", "fps=1/"+strconv.Itoa(interval),
+		"-frames:v", strconv.Itoa(count), prefix+"%03d.png")
+	c.Stderr = nil
+	c.Stdout = nil
+	c.Run()
+	return filepath.Glob(prefix + "*.png")
+}
+
+// hashFrames computes the perceptual hashes of frames sampled across a
+// video or an animated picture
+func hashFrames(in string, name string) ([]db.ImageHashes, error) {
+	frames, err := extractFrames(in,
+		config.Cfg.Media.Tmp+"/frames_"+name+"_")
+	for _, v := range frames {
+		defer os.Remove(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	hashes := []db.ImageHashes{}
+	for _, v := range frames {
+		f, err := os.Open(v)
+		if err != nil {
+			return nil, err
+		}
+		h, err := hashImage(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
+
+func areFramesBanned(in string, name string) error {
+	hashes, err := hashFrames(in, name)
+	if err != nil {
+		return err
+	}
+	for _, v := range hashes {
+		if err := db.IsImageBanned(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mediaFrames returns the hashes of the frames of a stored media
+func mediaFrames(media db.Media) ([]db.ImageHashes, error) {
+	path, err := mediaFile(media, "ban")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+	return hashFrames(path, media.Hash)
+}
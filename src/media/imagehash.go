@@ -1,40 +1,167 @@
 package media
 
 import (
+	"errors"
 	"github.com/corona10/goimagehash"
+	_ "golang.org/x/image/webp"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 	"io"
+	"sync"
 
 	"IB1/db"
 )
 
-func isImageBanned(r io.Reader) error {
+func hashImage(r io.Reader) (db.ImageHashes, error) {
 	img, _, err := image.Decode(r)
 	if err != nil {
-		return err
+		return db.ImageHashes{}, err
 	}
-	v, err := goimagehash.AverageHash(img)
+	average, err := goimagehash.AverageHash(img)
+	if err != nil {
+		return db.ImageHashes{}, err
+	}
+	difference, err := goimagehash.DifferenceHash(img)
+	if err != nil {
+		return db.ImageHashes{}, err
+	}
+	perception, err := goimagehash.PerceptionHash(img)
+	if err != nil {
+		return db.ImageHashes{}, err
+	}
+	return db.ImageHashes{
+		Average:    average.GetHash(),
+		Difference: difference.GetHash(),
+		Perception: perception.GetHash(),
+	}, nil
+}
+
+func isImageBanned(r io.Reader) error {
+	hashes, err := hashImage(r)
 	if err != nil {
 		return err
 	}
-	return db.IsImageBanned(*v)
+	return db.IsImageBanned(hashes)
+}
+
+func hashMedia(hash string, thumbnail bool) (db.ImageHashes, error) {
+	r, err := mediaReader(hash, thumbnail)
+	if err != nil {
+		return db.ImageHashes{}, err
+	}
+	defer r.Close()
+	return hashImage(r)
 }
 
 func Ban(hash string) error {
-	r, err := mediaReader(hash)
+	media, err := db.GetMedia(hash)
 	if err != nil {
 		return err
 	}
-	img, _, err := image.Decode(r)
+	if media.Type == db.MEDIA_AUDIO && !media.Cover {
+		return errors.New("audio without cover art cannot be banned")
+	}
+	hashes, err := hashMedia(hash, media.Type != db.MEDIA_PICTURE)
+	if err != nil && media.Type == db.MEDIA_PICTURE {
+		// pictures without a decoder are hashed from their thumbnail
+		hashes, err = hashMedia(hash, true)
+	}
 	if err != nil {
 		return err
 	}
-	v, err := goimagehash.AverageHash(img)
+	list := []db.ImageHashes{hashes}
+	if media.Type == db.MEDIA_VIDEO || media.Mime == "image/gif" {
+		frames, err := mediaFrames(media)
+		if err != nil {
+			return err
+		}
+		list = append(list, frames...)
+	}
+	return db.BanImage(hash, list...)
+}
+
+// MatchCheck is the progress of the check of the stored media against
+// imported hashes
+type MatchCheck struct {
+	Imported int
+	Total    int
+	Checked  int
+	Matches  int
+	Running  bool
+	Error    string
+}
+
+var matchCheck MatchCheck
+var matchCheckLock sync.Mutex
+
+func GetMatchCheck() MatchCheck {
+	matchCheckLock.Lock()
+	defer matchCheckLock.Unlock()
+	return matchCheck
+}
+
+// StartCountMatches counts the stored media matching a list of banned
+// hashes in the background
+func StartCountMatches(list []db.BannedImage) error {
+	matchCheckLock.Lock()
+	defer matchCheckLock.Unlock()
+	if matchCheck.Running {
+		return errors.New("the media are already being checked")
+	}
+	matchCheck = MatchCheck{Imported: len(list), Running: true}
+	go func() {
+		count, err := countMatches(list, func(checked int, total int) {
+			matchCheckLock.Lock()
+			defer matchCheckLock.Unlock()
+			matchCheck.Checked = checked
+			matchCheck.Total = total
+		})
+		matchCheckLock.Lock()
+		defer matchCheckLock.Unlock()
+		matchCheck.Running = false
+		matchCheck.Matches = count
+		if err != nil {
+			matchCheck.Error = err.Error()
+		}
+	}()
+	return nil
+}
+
+// CountMatches returns the number of stored media matching a list of banned
+// hashes, the frames of the videos not being checked
+func CountMatches(list []db.BannedImage) (int, error) {
+	return countMatches(list, func(int, int) {})
+}
+
+func countMatches(list []db.BannedImage,
+	progress func(checked int, total int)) (int, error) {
+	if len(list) == 0 {
+		return 0, nil
+	}
+	medias, err := db.GetAllMedia()
 	if err != nil {
-		return err
+		return 0, err
+	}
+	matches := db.ImageMatcher(list)
+	count := 0
+	for i, v := range medias {
+		progress(i, len(medias))
+		if v.Type == db.MEDIA_AUDIO && !v.Cover {
+			continue
+		}
+		hashes, err := hashMedia(v.Hash, v.Type != db.MEDIA_PICTURE)
+		if err != nil && v.Type == db.MEDIA_PICTURE {
+			hashes, err = hashMedia(v.Hash, true)
+		}
+		if err != nil {
+			continue
+		}
+		if matches(hashes) {
+			count++
+		}
 	}
-	return db.BanImage(*v)
+	progress(len(medias), len(medias))
+	return count, nil
 }
@@ -1,23 +1,25 @@
 package media
 
 import (
-	"bytes"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	"golang.org/x/crypto/blake2b"
+	"image"
 	"io"
-	"mime/multipart"
+	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
-
-	"github.com/gabriel-vasile/mimetype"
+	"unicode"
+	"unicode/utf8"
 
 	"IB1/config"
 	"IB1/db"
 	"IB1/notify"
+	"IB1/storage"
 )
 
 func IsMedia(media string, mediaType db.MediaType) bool {
@@ -73,23 +75,11 @@ var extensions = map[string]db.MediaType{
 	".webp": db.MEDIA_PICTURE,
 	".webm": db.MEDIA_VIDEO,
 	".mp4":  db.MEDIA_VIDEO,
-}
-
-func saveUploadedFile(file *multipart.FileHeader, out string) error {
-	src, err := file.Open()
-	if err != nil {
-		return err
-	}
-	defer src.Close()
-
-	dst, err := os.Create(out)
-	if err != nil {
-		return err
-	}
-	defer dst.Close()
-
-	_, err = io.Copy(dst, src)
-	return err
+	".mp3":  db.MEDIA_AUDIO,
+	".ogg":  db.MEDIA_AUDIO,
+	".oga":  db.MEDIA_AUDIO,
+	".flac": db.MEDIA_AUDIO,
+	".wav":  db.MEDIA_AUDIO,
 }
 
 func validExtension(extension string) (db.MediaType, error) {
@@ -97,56 +87,65 @@ func validExtension(extension string) (db.MediaType, error) {
 	if mediaType == db.MEDIA_VIDEO && !config.Cfg.Media.AllowVideos {
 		return 0, errors.New("video support not enabled")
 	}
+	if mediaType == db.MEDIA_AUDIO && !config.Cfg.Media.AllowAudio {
+		return 0, errors.New("audio support not enabled")
+	}
 	if !exist {
 		return 0, errors.New("forbidden file extension")
 	}
 	return mediaType, nil
 }
 
-func UploadFile(file *multipart.FileHeader,
-	approved bool, spoiler bool) (string, error) {
-
-	if uint64(file.Size) > config.Cfg.Media.MaxSize {
-		return "", errors.New("media is above size limit")
-	}
-
-	// write file to disk
-	name, err := uniqueRandomName()
-	if err != nil {
-		return "", err
-	}
-	path := config.Cfg.Media.Tmp + "/" + name
-	if err = saveUploadedFile(file, path); err != nil {
-		return "", err
-	}
-	defer os.Remove(path)
+// process checks, cleans and stores an upload, returning the name of the
+// stored media
+func (upload *Upload) process(board db.Board, approved bool,
+	spoiler bool) (string, error) {
 
-	// verify extension
-	mime, err := mimetype.DetectFile(path)
-	if err != nil {
-		return "", err
-	}
-	extension := mime.Extension()
-	mediaType, err := validExtension(extension)
-	if err != nil {
-		return "", err
-	}
+	path := upload.path
+	name := upload.name
+	extension := upload.Extension
+	mediaType := upload.Type
+	var err error
 
 	// check if media is banned
 	mediaPath := path
+	info := db.MediaInfo{}
 	if mediaType == db.MEDIA_VIDEO {
+		info, err = probeVideo(path, extension)
+		if err != nil {
+			return "", err
+		}
 		mediaPath = config.Cfg.Media.Tmp + "/frame_" + name + ".png"
 		if err := extractFrame(path, mediaPath); err != nil {
 			return "", err
 		}
 		defer os.Remove(mediaPath)
+	} else if mediaType == db.MEDIA_AUDIO {
+		info.Duration, err = probeDuration(path)
+		if err != nil {
+			return "", err
+		}
+		if err := checkDuration(info.Duration); err != nil {
+			return "", err
+		}
+		mediaPath = config.Cfg.Media.Tmp + "/cover_" + name + ".png"
+		info.Cover = extractCover(path, mediaPath) == nil
+		defer os.Remove(mediaPath)
 	}
-	f, err := os.Open(mediaPath)
-	if err != nil {
-		return "", err
+	if mediaType != db.MEDIA_AUDIO || info.Cover {
+		f, err := os.Open(mediaPath)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if err := isImageBanned(f); err != nil {
+			return "", err
+		}
 	}
-	if err := isImageBanned(f); err != nil {
-		return "", err
+	if mediaType == db.MEDIA_VIDEO || extension == ".gif" {
+		if err := areFramesBanned(path, name); err != nil {
+			return "", err
+		}
 	}
 
 	// clean up the metadata
@@ -157,6 +156,18 @@ func UploadFile(file *multipart.FileHeader,
 		}
 		os.Remove(path)
 		defer os.Remove(out)
+	} else if mediaType == db.MEDIA_VIDEO {
+		if err := cleanVideo(path, out, board.DropAudio); err != nil {
+			return "", err
+		}
+		os.Remove(path)
+		defer os.Remove(out)
+	} else if mediaType == db.MEDIA_AUDIO {
+		if err := cleanAudio(path, out); err != nil {
+			return "", err
+		}
+		os.Remove(path)
+		defer os.Remove(out)
 	} else {
 		out = path
 	}
@@ -166,70 +177,98 @@ func UploadFile(file *multipart.FileHeader,
 	if err != nil {
 		return "", err
 	}
-	if config.Cfg.Media.InDatabase { // store media in database
-		tn := config.Cfg.Media.Tmp + "/thumbnail_" + hash + ".png"
-		src := out
-		if mediaType == db.MEDIA_VIDEO {
-			src = config.Cfg.Media.Tmp + "/frame_" + hash + ".png"
-			if err := extractFrame(out, src); err != nil {
-				return "", err
-			}
-			defer os.Remove(src)
-		}
-		if err := thumbnail(src, tn); err != nil {
-			return "", err
-		}
-		defer os.Remove(tn)
-		tn_data, err := os.ReadFile(tn)
-		if err != nil {
-			return "", err
-		}
-		data, err := os.ReadFile(out)
-		if err != nil {
-			return "", err
-		}
-		toApprove, err := db.AddMedia(data, tn_data, mediaType,
-			hash, mime.String(), approved, spoiler)
+
+	if mediaType == db.MEDIA_PICTURE {
+		info.Width, info.Height, err = dimensions(out)
 		if err != nil {
 			return "", err
 		}
-		if toApprove {
-			err = notify.Notify(hash)
-		}
-		return hash + extension, err
 	}
-	toApprove, err := db.AddMedia(nil, nil, mediaType,
-		hash, mime.String(), approved, spoiler)
-	if toApprove && err == nil {
-		err = notify.Notify(hash)
+
+	if _, err := db.GetMedia(hash); err == nil {
+		// already uploaded
+		return hash + extension, nil
 	}
+
+	// create thumbnails
+	tn, err := makeThumbnails(mediaType, out, extension, info,
+		config.Cfg.Media.Tmp+"/thumbnail_"+hash+"_")
 	if err != nil {
 		return "", err
 	}
-	media := config.Cfg.Media.Path + "/" + hash + extension
-	err = move(out, media)
+	defer tn.remove()
+
+	fi, err := os.Stat(out)
 	if err != nil {
 		return "", err
 	}
-
-	// create thumbnail
-	if mediaType == db.MEDIA_VIDEO {
-		dst := config.Cfg.Media.Tmp + "/frame_" + hash + ".png"
-		if err := extractFrame(media, dst); err != nil {
-			return "", err
-		}
-		media = dst
-		defer os.Remove(media)
+	media := db.Media{
+		Hash: hash, Mime: upload.mime, Approved: approved,
+		HideThumbnail: spoiler, Type: mediaType, Extension: extension,
+		ThumbnailExtension: tn.Extension, Size: fi.Size(),
+		MediaInfo: info,
 	}
-	err = thumbnail(media,
-		config.Cfg.Media.Path+"/thumbnail/"+hash+".png")
+	toApprove, err := db.AddMedia(media, func() error {
+		if err := store(media.Key(), out); err != nil {
+			return err
+		}
+		return storeThumbnails(media, tn)
+	})
 	if err != nil {
 		return "", err
 	}
-
+	if toApprove {
+		if err := notify.PendingMedia(hash, board.Name); err != nil {
+			log.Println(err)
+		}
+	}
 	return hash + extension, nil
 }
 
+func dimensions(path string) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	v, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return v.Width, v.Height, nil
+}
+
+// SanitizeFilename returns the name of an uploaded file as displayed on
+// its post, with the extension of the stored media
+func SanitizeFilename(name string, media string) string {
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if runes := []rune(name); len(runes) > 64 {
+		name = string(runes[:64])
+	}
+	if name == "" {
+		return ""
+	}
+	return name + filepath.Ext(media)
+}
+
+// store saves a file in the media storage
+func store(key string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return storage.Media().Put(key, f)
+}
+
 func extractFrame(in string, out string) error {
 	var c *exec.Cmd
 	if strings.HasSuffix(in, ".gif") {
@@ -249,58 +288,22 @@ func extractFrame(in string, out string) error {
 	return err
 }
 
-func move(source string, destination string) error {
-	src, err := os.Open(source)
-	if err != nil {
-		return err
-	}
-	defer src.Close()
-	dst, err := os.Create(destination)
-	if err != nil {
-		return err
-	}
-	defer dst.Close()
-	_, err = io.Copy(dst, src)
-	if err != nil {
-		return err
+// preview saves the image a thumbnail is made from for videos and audio
+func preview(mediaType db.MediaType, in string, out string) error {
+	if mediaType == db.MEDIA_AUDIO {
+		return audioPreview(in, out)
 	}
-	fi, err := os.Stat(source)
-	if err != nil {
-		os.Remove(destination)
-		return err
-	}
-	err = os.Chmod(destination, fi.Mode())
-	if err != nil {
-		os.Remove(destination)
-		return err
-	}
-	os.Remove(source)
-	return nil
+	return extractFrame(in, out)
 }
 
-func mediaReader(hash string) (io.Reader, error) {
-	isPicture := IsMedia(hash, db.MEDIA_PICTURE)
-	if !config.Cfg.Media.InDatabase {
-		if !isPicture {
-			return os.Open(config.Cfg.Media.Path +
-				"/thumbnail/" + hash + ".png")
-		}
-		post, err := db.GetPostFromMedia(hash)
-		if err != nil {
-			return nil, err
-		}
-		return os.Open(config.Cfg.Media.Path + "/" + post.Media)
-	}
-	var data []byte
-	var err error
-	if isPicture {
-		data, _, err = db.GetMediaData(hash)
-	} else {
-		data, err = db.GetThumbnail(hash)
-	}
+// mediaReader opens the file of a media or its thumbnail
+func mediaReader(hash string, thumbnail bool) (io.ReadCloser, error) {
+	media, err := db.GetMedia(hash)
 	if err != nil {
 		return nil, err
 	}
-	r := bytes.NewReader(data)
-	return r, nil
+	if thumbnail {
+		return storage.Media().Get(media.ThumbnailKey())
+	}
+	return storage.Media().Get(media.Key())
 }
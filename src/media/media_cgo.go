@@ -2,7 +2,11 @@
 package media
 
 import (
+	"errors"
 	"github.com/h2non/bimg"
+	"path/filepath"
+
+	"IB1/config"
 )
 
 func cleanImage(in string, out string) error {
@@ -12,6 +16,8 @@ func cleanImage(in string, out string) error {
 		return err
 	}
 
+	// libvips rotates the picture according to its EXIF orientation
+	// before the metadata is stripped
 	img, err := bimg.NewImage(buffer).Process(
 		bimg.Options{StripMetadata: true})
 	if err != nil {
@@ -22,7 +28,13 @@ func cleanImage(in string, out string) error {
 	return nil
 }
 
-func thumbnail(in string, out string) error {
+var thumbnailTypes = map[string]bimg.ImageType{
+	".png":  bimg.PNG,
+	".jpg":  bimg.JPEG,
+	".webp": bimg.WEBP,
+}
+
+func thumbnail(in string, out string, size int) error {
 
 	buffer, err := bimg.Read(in)
 	if err != nil {
@@ -31,24 +43,34 @@ func thumbnail(in string, out string) error {
 
 	img := bimg.NewImage(buffer)
 
-	size, err := img.Size()
+	dimensions, err := img.Size()
 	if err != nil {
 		return err
 	}
-	w := size.Width
-	h := size.Height
-	if w > h {
-		h = h * 200 / w
-		w = 200
-	} else {
-		w = w * 200 / h
-		h = 200
-	}
+	w, h := fit(dimensions.Width, dimensions.Height, size)
 
-	newImage, err := img.Resize(w, h)
+	newImage, err := img.Process(bimg.Options{
+		Width:   w,
+		Height:  h,
+		Force:   true,
+		Type:    thumbnailTypes[filepath.Ext(out)],
+		Quality: config.Cfg.Media.ThumbnailQuality,
+	})
 	if err != nil {
 		return err
 	}
 
 	return bimg.Write(out, newImage)
 }
+
+// CheckThumbnailFormat verifies that thumbnails can be saved in a format
+func CheckThumbnailFormat(format string) error {
+	extension, ok := ThumbnailFormats[format]
+	if !ok {
+		return errors.New("unknown thumbnail format")
+	}
+	if !bimg.IsTypeSupportedSave(thumbnailTypes[extension]) {
+		return errors.New("format not supported by libvips")
+	}
+	return nil
+}
@@ -2,9 +2,12 @@
 package media
 
 import (
+	"errors"
 	"github.com/anthonynsimon/bild/imgio"
 	"github.com/anthonynsimon/bild/transform"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"IB1/config"
@@ -16,6 +19,8 @@ func cleanImage(in string, out string) error {
 	if err != nil {
 		return err
 	}
+	// the orientation is lost with the metadata
+	img = orient(img, jpegOrientation(in))
 
 	enc := imgio.PNGEncoder()
 	ext := strings.Split(out, ".")
@@ -25,13 +30,14 @@ func cleanImage(in string, out string) error {
 	return imgio.Save(out, img, enc)
 }
 
-func thumbnail(in string, out string) error {
+func thumbnail(in string, out string, size int) error {
 
 	// fallback to ffmpeg if source is a gif image
 	parts := strings.Split(in, ".")
 	if len(parts) > 0 && parts[len(parts)-1] == "gif" {
 		parts = strings.Split(out, "/")
 		dst := config.Cfg.Media.Tmp + "/frame_" + parts[len(parts)-1]
+		dst = strings.TrimSuffix(dst, filepath.Ext(dst)) + ".png"
 		if err := extractFrame(in, dst); err != nil {
 			return err
 		}
@@ -44,20 +50,35 @@ func thumbnail(in string, out string) error {
 		return err
 	}
 
-	size := img.Bounds().Size()
-	if err != nil {
-		return err
-	}
-	w := size.X
-	h := size.Y
-	if w > h {
-		h = h * 200 / w
-		w = 200
-	} else {
-		w = w * 200 / h
-		h = 200
-	}
+	bounds := img.Bounds().Size()
+	w, h := fit(bounds.X, bounds.Y, size)
 
 	img = transform.Resize(img, w, h, transform.Linear)
+	switch filepath.Ext(out) {
+	case ".jpg":
+		return imgio.Save(out, img,
+			imgio.JPEGEncoder(config.Cfg.Media.ThumbnailQuality))
+	case ".webp":
+		// bild has no WebP encoder
+		tmp := strings.TrimSuffix(out, ".webp") + ".png"
+		if err := imgio.Save(tmp, img, imgio.PNGEncoder()); err != nil {
+			return err
+		}
+		defer os.Remove(tmp)
+		return encodeWebP(tmp, out)
+	}
 	return imgio.Save(out, img, imgio.PNGEncoder())
 }
+
+// CheckThumbnailFormat verifies that thumbnails can be saved in a format
+func CheckThumbnailFormat(format string) error {
+	if _, ok := ThumbnailFormats[format]; !ok {
+		return errors.New("unknown thumbnail format")
+	}
+	if format == "webp" {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			return errors.New("WebP thumbnails require ffmpeg")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package media
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	long := strings.Repeat("é", 70)
+	tests := []struct {
+		name  string
+		media string
+		want  string
+	}{
+		{"picture.png", "abc.png", "picture.png"},
+		{"picture.PNG", "abc.png", "picture.png"},
+		{"archive.tar.gz", "abc.webm", "archive.tar.webm"},
+		{"no extension", "abc.jpg", "no extension.jpg"},
+		{"../../etc/passwd", "abc.png", "passwd.png"},
+		{`C:\Users\anon\cat.jpg`, "abc.jpg", "cat.jpg"},
+		{"  spaced  .png", "abc.png", "spaced.png"},
+		{"new\nline\r\x00.gif", "abc.gif", "newline.gif"},
+		{"bad\xffutf8.png", "abc.png", "badutf8.png"},
+		{"\u202egnp.exe", "abc.png", "\u202egnp.png"},
+		{long + ".png", "abc.png", strings.Repeat("é", 64) + ".png"},
+		{".png", "abc.png", ""},
+		{"dir/", "abc.png", ""},
+		{" \t.png", "abc.png", ""},
+		{"", "abc.png", ""},
+	}
+	for _, test := range tests {
+		if got := SanitizeFilename(test.name, test.media); got != test.want {
+			t.Errorf("SanitizeFilename(%q, %q) = %q, want %q", test.name,
+				test.media, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"os"
+)
+
+// jpegOrientation returns the EXIF orientation of a JPEG picture, 1 when
+// it has none
+func jpegOrientation(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	data = data[2:]
+	for len(data) >= 4 && data[0] == 0xff {
+		marker := data[1]
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if marker == 0xda || length < 2 || len(data) < length+2 {
+			break
+		}
+		segment := data[4 : length+2]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		data = data[length+2:]
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag of the first IFD of a TIFF
+// header
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			v := int(order.Uint16(tiff[entry+8:]))
+			if v >= 1 && v <= 8 {
+				return v
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// orient transforms a picture so it is displayed upright without its EXIF
+// orientation
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if orientation >= 5 {
+		dst = image.NewNRGBA(image.Rect(0, 0, h, w))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := x, y
+			switch orientation {
+			case 2:
+				dx = w - 1 - x
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dy = h - 1 - y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.SetNRGBA(dx, dy, src.NRGBAAt(x, y))
+		}
+	}
+	return dst
+}
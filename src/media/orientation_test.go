@@ -0,0 +1,113 @@
+package media
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// tiffHeader builds a TIFF header whose first IFD holds the given tags
+func tiffHeader(order byteOrder, tags map[uint16]uint16) []byte {
+	data := []byte("II")
+	if order == binary.BigEndian {
+		data = []byte("MM")
+	}
+	data = order.AppendUint16(data, 42)
+	data = order.AppendUint32(data, 8)
+	data = order.AppendUint16(data, uint16(len(tags)))
+	for tag, value := range tags {
+		data = order.AppendUint16(data, tag)
+		data = order.AppendUint16(data, 3)
+		data = order.AppendUint32(data, 1)
+		data = order.AppendUint16(data, value)
+		data = order.AppendUint16(data, 0)
+	}
+	return order.AppendUint32(data, 0)
+}
+
+func TestExifOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		tiff []byte
+		want int
+	}{
+		{"little endian", tiffHeader(binary.LittleEndian,
+			map[uint16]uint16{0x0112: 6}), 6},
+		{"big endian", tiffHeader(binary.BigEndian,
+			map[uint16]uint16{0x0112: 8}), 8},
+		{"other tags", tiffHeader(binary.LittleEndian,
+			map[uint16]uint16{0x0100: 640}), 1},
+		{"out of range", tiffHeader(binary.BigEndian,
+			map[uint16]uint16{0x0112: 9}), 1},
+		{"invalid order", append([]byte("XX"),
+			tiffHeader(binary.BigEndian, nil)[2:]...), 1},
+		{"truncated entry", tiffHeader(binary.LittleEndian,
+			map[uint16]uint16{0x0112: 3})[:14], 1},
+		{"offset outside", []byte("II*\x00\xff\x00\x00\x00"), 1},
+		{"too short", []byte("II*\x00"), 1},
+		{"empty", nil, 1},
+	}
+	for _, test := range tests {
+		if got := exifOrientation(test.tiff); got != test.want {
+			t.Errorf("%s: exifOrientation() = %d, want %d", test.name,
+				got, test.want)
+		}
+	}
+}
+
+// segment builds a JPEG marker segment
+func segment(marker byte, payload []byte) []byte {
+	data := []byte{0xff, marker}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(payload)+2))
+	return append(data, payload...)
+}
+
+func TestJpegOrientation(t *testing.T) {
+	exif := append([]byte("Exif\x00\x00"), tiffHeader(binary.BigEndian,
+		map[uint16]uint16{0x0112: 3})...)
+	soi := []byte{0xff, 0xd8}
+	join := func(parts ...[]byte) []byte {
+		data := []byte{}
+		for _, v := range parts {
+			data = append(data, v...)
+		}
+		return data
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"exif", join(soi, segment(0xe1, exif)), 3},
+		{"after jfif", join(soi, segment(0xe0, []byte("JFIF\x00\x01\x02")),
+			segment(0xe1, exif)), 3},
+		{"xmp only", join(soi,
+			segment(0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00"))), 1},
+		{"after scan", join(soi, segment(0xda, []byte{0, 0}),
+			segment(0xe1, exif)), 1},
+		{"truncated", join(soi, segment(0xe1, exif))[:20], 1},
+		{"no exif", join(soi, segment(0xe0, []byte("JFIF\x00"))), 1},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), 1},
+	}
+	dir := t.TempDir()
+	for i, test := range tests {
+		path := filepath.Join(dir, strings.Repeat("x", i+1)+".jpg")
+		if err := os.WriteFile(path, test.data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if got := jpegOrientation(path); got != test.want {
+			t.Errorf("%s: jpegOrientation() = %d, want %d", test.name,
+				got, test.want)
+		}
+	}
+	if got := jpegOrientation(filepath.Join(dir, "missing")); got != 1 {
+		t.Errorf("jpegOrientation() of a missing file = %d, want 1", got)
+	}
+}
@@ -0,0 +1,151 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/blake2b"
+	"io"
+	"log"
+	"mime/multipart"
+	"os"
+
+	"github.com/gabriel-vasile/mimetype"
+
+	"IB1/config"
+	"IB1/db"
+)
+
+// Upload is a file received with a post and waiting to be processed
+type Upload struct {
+	// Hash is the hash of the file as it was uploaded
+	Hash      string
+	Extension string
+	Type      db.MediaType
+	name      string
+	path      string
+	mime      string
+	discarded bool
+}
+
+type job struct {
+	upload   *Upload
+	board    db.Board
+	approved bool
+	spoiler  bool
+	done     func(string, error)
+}
+
+var queue chan job
+
+// slots limits the number of uploads being queued or processed
+var slots chan struct{}
+
+var errQueueFull = errors.New(
+	"too many uploads are being processed, try again later")
+
+// StartWorkers starts the goroutines processing the uploaded media
+func StartWorkers() {
+	if err := db.ClearProcessing(); err != nil {
+		log.Println(err)
+	}
+	workers := max(config.Cfg.Media.Workers, 1)
+	size := workers + max(config.Cfg.Media.QueueSize, 0)
+	slots = make(chan struct{}, size)
+	queue = make(chan job, size)
+	for range workers {
+		go worker()
+	}
+}
+
+func worker() {
+	for j := range queue {
+		name, err := j.upload.process(j.board, j.approved, j.spoiler)
+		j.upload.Discard()
+		j.done(name, err)
+	}
+}
+
+// Prepare saves an uploaded file to the temporary directory and verifies
+// its type, the upload must then be processed or discarded
+func Prepare(file *multipart.FileHeader) (*Upload, error) {
+	if uint64(file.Size) > config.Cfg.Media.MaxSize {
+		return nil, errors.New("media is above size limit")
+	}
+	select {
+	case slots <- struct{}{}:
+	default:
+		return nil, errQueueFull
+	}
+	upload := &Upload{}
+	if err := upload.save(file); err != nil {
+		upload.Discard()
+		return nil, err
+	}
+	return upload, nil
+}
+
+func (upload *Upload) save(file *multipart.FileHeader) error {
+	name, err := uniqueRandomName()
+	if err != nil {
+		return err
+	}
+	upload.name = name
+	upload.path = config.Cfg.Media.Tmp + "/" + name
+
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(upload.path)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	h, err := blake2b.New256(config.Cfg.Media.Key)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.MultiWriter(dst, h), src); err != nil {
+		return err
+	}
+	upload.Hash = fmt.Sprintf("%x", h.Sum(nil))
+
+	// verify extension
+	mime, err := mimetype.DetectFile(upload.path)
+	if err != nil {
+		return err
+	}
+	upload.mime = mime.String()
+	upload.Extension = mime.Extension()
+	upload.Type, err = validExtension(upload.Extension)
+	if err != nil {
+		return err
+	}
+	if upload.Extension == ".oga" {
+		upload.Extension = ".ogg"
+	}
+	return nil
+}
+
+// Discard removes the temporary file of an upload and frees its slot
+func (upload *Upload) Discard() {
+	if upload.discarded {
+		return
+	}
+	upload.discarded = true
+	if upload.path != "" {
+		os.Remove(upload.path)
+	}
+	<-slots
+}
+
+// Process queues an upload, done is called with the name of the stored
+// media once it has been processed
+func Process(upload *Upload, board db.Board, approved bool, spoiler bool,
+	done func(string, error)) {
+	queue <- job{
+		upload: upload, board: board, approved: approved,
+		spoiler: spoiler, done: done,
+	}
+}
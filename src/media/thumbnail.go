@@ -0,0 +1,228 @@
+package media
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"IB1/config"
+	"IB1/db"
+	"IB1/storage"
+)
+
+// ThumbnailFormats maps the thumbnail formats to their extension
+var ThumbnailFormats = map[string]string{
+	"png":  ".png",
+	"jpeg": ".jpg",
+	"webp": ".webp",
+}
+
+// thumbnails holds the paths of the thumbnails made for a media, Reply
+// being empty when replies use the same size as the OP
+type thumbnails struct {
+	Extension string
+	OP        string
+	Reply     string
+}
+
+func (t thumbnails) remove() {
+	os.Remove(t.OP)
+	if t.Reply != "" {
+		os.Remove(t.Reply)
+	}
+}
+
+// fit scales dimensions down or up so the largest one equals size
+func fit(w int, h int, size int) (int, int) {
+	if w > h {
+		return size, max(h*size/w, 1)
+	}
+	return max(w*size/h, 1), size
+}
+
+func isAnimated(mediaType db.MediaType, extension string,
+	info db.MediaInfo) bool {
+	cfg := config.Cfg.Media
+	if !cfg.AnimatedThumbnails {
+		return false
+	}
+	if extension == ".gif" {
+		return true
+	}
+	return mediaType == db.MEDIA_VIDEO && cfg.AnimatedDuration > 0 &&
+		info.Duration <= float64(cfg.AnimatedDuration)
+}
+
+// animatedThumbnail makes an animated GIF of the first seconds of a video
+// or an animated picture
+func animatedThumbnail(in string, out string, size int) error {
+	args := []string{"-i", in, "-an"}
+	if d := config.Cfg.Media.AnimatedDuration; d > 0 {
+		args = append(args, "-t", strconv.Itoa(d))
+	}
+	s := strconv.Itoa(size)
+	args = append(args, "-vf", "fps=10,scale="+s+":"+s+
+		":force_original_aspect_ratio=decrease,"+
+		"split[a][b];[a]palettegen[p];[b][p]paletteuse",
+		"-loop", "0", out)
+	c := exec.Command("ffmpeg", args...)
+	c.Stderr = nil
+	c.Stdout = nil
+	c.Run()
+	_, err := os.Stat(out)
+	return err
+}
+
+// encodeWebP converts a picture to WebP with the thumbnail quality
+func encodeWebP(in string, out string) error {
+	c := exec.Command("ffmpeg", "-i", in, "-c:v", "libwebp",
+		"-quality", strconv.Itoa(config.Cfg.Media.ThumbnailQuality), out)
+	c.Stderr = nil
+	c.Stdout = nil
+	c.Run()
+	_, err := os.Stat(out)
+	return err
+}
+
+// makeThumbnails creates the thumbnails of a media in the temporary
+// directory, their names starting with prefix
+func makeThumbnails(mediaType db.MediaType, in string, extension string,
+	info db.MediaInfo, prefix string) (thumbnails, error) {
+	cfg := config.Cfg.Media
+	animated := isAnimated(mediaType, extension, info)
+	t := thumbnails{Extension: ".png"}
+	if animated {
+		t.Extension = ".gif"
+	} else if v, ok := ThumbnailFormats[cfg.ThumbnailFormat]; ok {
+		t.Extension = v
+	}
+
+	src := in
+	if !animated && mediaType != db.MEDIA_PICTURE {
+		src = prefix + "frame.png"
+		if err := preview(mediaType, in, src); err != nil {
+			return t, err
+		}
+		defer os.Remove(src)
+	}
+	create := func(out string, size int) error {
+		if animated {
+			return animatedThumbnail(src, out, size)
+		}
+		return thumbnail(src, out, size)
+	}
+
+	t.OP = prefix + "thumbnail" + t.Extension
+	if err := create(t.OP, cfg.ThumbnailSize); err != nil {
+		return t, err
+	}
+	if cfg.ReplyThumbnailSize > 0 &&
+		cfg.ReplyThumbnailSize != cfg.ThumbnailSize {
+		t.Reply = prefix + "reply" + t.Extension
+		if err := create(t.Reply, cfg.ReplyThumbnailSize); err != nil {
+			t.remove()
+			return t, err
+		}
+	}
+	return t, nil
+}
+
+// storeThumbnails saves the thumbnails of a media in the storage
+func storeThumbnails(media db.Media, t thumbnails) error {
+	if err := store(media.ThumbnailKey(), t.OP); err != nil {
+		return err
+	}
+	if t.Reply == "" {
+		return nil
+	}
+	return store(media.ReplyThumbnailKey(), t.Reply)
+}
+
+// mediaFile copies a stored media to the temporary directory
+func mediaFile(media db.Media, name string) (string, error) {
+	r, err := storage.Media().Get(media.Key())
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	path := config.Cfg.Media.Tmp + "/" + name + "_" + media.Key()
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, r)
+	f.Close()
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+func regenerateThumbnails(media db.Media) error {
+	path, err := mediaFile(media, "regenerate")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	t, err := makeThumbnails(media.Type, path, filepath.Ext(path),
+		media.MediaInfo, config.Cfg.Media.Tmp+"/regenerate_"+media.Hash+"_")
+	if err != nil {
+		return err
+	}
+	defer t.remove()
+	// the previous thumbnails are only removed once the new ones are
+	// stored, in case they have another extension
+	previous := media
+	media.ThumbnailExtension = t.Extension
+	if err := storeThumbnails(media, t); err != nil {
+		return err
+	}
+	err = db.SetThumbnailExtension(media.Hash, media.ThumbnailExtension)
+	if err != nil {
+		return err
+	}
+	if t.Reply == "" {
+		err := storage.Media().Delete(media.ReplyThumbnailKey())
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+	if previous.ThumbnailKey() == media.ThumbnailKey() {
+		return nil
+	}
+	for _, key := range []string{
+		previous.ThumbnailKey(), previous.ReplyThumbnailKey(),
+	} {
+		err := storage.Media().Delete(key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegenerateThumbnails rebuilds the thumbnails of every media with the
+// current settings, returning how many were rebuilt
+func RegenerateThumbnails() (int, error) {
+	medias, err := db.GetAllMedia()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, v := range medias {
+		if err := regenerateThumbnails(v); err != nil {
+			log.Println(v.Hash+":", err)
+			continue
+		}
+		count++
+	}
+	if count == 0 && len(medias) > 0 {
+		return 0, errors.New("no thumbnail could be regenerated")
+	}
+	return count, nil
+}
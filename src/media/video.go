@@ -0,0 +1,134 @@
+package media
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"IB1/config"
+	"IB1/db"
+)
+
+var errInvalidVideo = errors.New("invalid video file")
+
+type videoFormat struct {
+	// name of the container as reported by ffprobe
+	container string
+	video     []string
+	audio     []string
+}
+
+// codecs accepted for each container, all of them being playable by
+// browsers
+var videoFormats = map[string]videoFormat{
+	".webm": {
+		container: "webm",
+		video:     []string{"vp8", "vp9", "av1"},
+		audio:     []string{"vorbis", "opus"},
+	},
+	".mp4": {
+		container: "mp4",
+		video:     []string{"h264", "vp9", "av1"},
+		audio:     []string{"aac", "mp3", "opus"},
+	},
+}
+
+type probe struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+	} `json:"streams"`
+}
+
+func checkDuration(duration float64) error {
+	limit := config.Cfg.Media.MaxDuration
+	if limit > 0 && duration > float64(limit) {
+		return errors.New("media is above duration limit")
+	}
+	return nil
+}
+
+// probeVideo verifies that the container of a video matches its
+// extension and that its streams can be played, returning its duration
+// and dimensions
+func probeVideo(in string, extension string) (db.MediaInfo, error) {
+	info := db.MediaInfo{}
+	format, ok := videoFormats[extension]
+	if !ok {
+		return info, errInvalidVideo
+	}
+	c := exec.Command("ffprobe", "-v", "error",
+		"-show_entries", "format=format_name,duration:"+
+			"stream=codec_type,codec_name,width,height", "-of", "json", in)
+	out, err := c.Output()
+	if err != nil {
+		return info, errInvalidVideo
+	}
+	var v probe
+	if err := json.Unmarshal(out, &v); err != nil {
+		return info, errInvalidVideo
+	}
+	containers := strings.Split(v.Format.FormatName, ",")
+	if !slices.Contains(containers, format.container) {
+		return info, errors.New("the container does not match the file type")
+	}
+	hasVideo := false
+	for _, stream := range v.Streams {
+		switch stream.CodecType {
+		case "video":
+			if !slices.Contains(format.video, stream.CodecName) {
+				return info, errors.New("unsupported video codec")
+			}
+			if !hasVideo {
+				info.Width = stream.Width
+				info.Height = stream.Height
+			}
+			hasVideo = true
+		case "audio":
+			if !slices.Contains(format.audio, stream.CodecName) {
+				return info, errors.New("unsupported audio codec")
+			}
+		}
+	}
+	if !hasVideo {
+		return info, errInvalidVideo
+	}
+	info.Duration, err = strconv.ParseFloat(v.Format.Duration, 64)
+	if err != nil || info.Duration <= 0 {
+		return info, errInvalidVideo
+	}
+	return info, checkDuration(info.Duration)
+}
+
+// cleanVideo remuxes the first video and audio streams without the
+// metadata, chapters, subtitles and data streams
+func cleanVideo(in string, out string, dropAudio bool) error {
+	args := []string{"-i", in, "-map", "0:v:0"}
+	if dropAudio {
+		args = append(args, "-an")
+	} else {
+		args = append(args, "-map", "0:a:0?")
+	}
+	args = append(args, "-map_metadata", "-1", "-map_chapters", "-1",
+		"-sn", "-dn", "-fflags", "+bitexact", "-c", "copy")
+	if strings.HasSuffix(out, ".mp4") {
+		args = append(args, "-movflags", "+faststart")
+	}
+	c := exec.Command("ffmpeg", append(args, out)...)
+	c.Stderr = os.Stderr
+	c.Stdout = nil
+	if err := c.Run(); err != nil {
+		return errInvalidVideo
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"IB1/config"
+	"IB1/db"
+	"IB1/util"
+)
+
+// PendingMedia notifies that a media uploaded on a board waits for
+// approval, with links approving or denying it without being logged in
+func PendingMedia(media string, board string) error {
+	// the secret letting the channels approve the media is only made
+	// when one of them follows the board
+	channels, err := subscribers(db.EVENT_PENDING_MEDIA, board)
+	if err != nil || len(channels) == 0 {
+		return err
+	}
+	secret, err := util.NewTextToken()
+	if err != nil {
+		return err
+	}
+	err = db.ApprovalBypass{}.Add(db.ApprovalBypass{
+		Secret: secret,
+		Hash:   media,
+	})
+	if err != nil {
+		return err
+	}
+	suffix := secret + "/" + media + ".png"
+	n := newNotification(db.EVENT_PENDING_MEDIA, "Pending media approval",
+		"New media file on "+config.Cfg.Web.Domain+" pending approval",
+		"/approval")
+	n.Board = board
+	n.Image = prefix() + "/media/thumbnail/" + suffix
+	n.Actions = []Action{
+		{"Approve", prefix() + "/approval/accept/" + suffix},
+		{"Deny", prefix() + "/approval/deny/" + suffix},
+	}
+	Send(n)
+	return nil
+}
+
+// NewThread notifies that a thread was created on a board
+func NewThread(board string, number int, title string) {
+	if title == "" {
+		title = "No." + strconv.Itoa(number)
+	}
+	n := newNotification(db.EVENT_NEW_THREAD, "New thread on /"+board+"/",
+		title, "/"+board+"/"+strconv.Itoa(number))
+	n.Board = board
+	Send(n)
+}
+
+var loginFailures struct {
+	count int
+	since time.Time
+	mutex sync.Mutex
+}
+
+// LoginFailed counts the failed logins, a notification being sent when
+// too many failed during the window
+func LoginFailed() {
+	limit := config.Cfg.Notify.LoginFailures
+	if limit <= 0 {
+		return
+	}
+	window := time.Duration(config.Cfg.Notify.LoginWindow) * time.Minute
+	loginFailures.mutex.Lock()
+	defer loginFailures.mutex.Unlock()
+	if time.Since(loginFailures.since) > window {
+		loginFailures.count = 0
+		loginFailures.since = time.Now()
+	}
+	loginFailures.count++
+	if loginFailures.count != limit {
+		return
+	}
+	Send(newNotification(db.EVENT_LOGIN_FAILURES, "Failed logins",
+		strconv.Itoa(limit)+" logins failed in less than "+
+			strconv.Itoa(config.Cfg.Notify.LoginWindow)+" minutes",
+		"/login"))
+}
+
+// checkCertificate notifies when the SSL certificate expires soon
+func checkCertificate() {
+	days := config.Cfg.Notify.ExpiryDays
+	if !config.Cfg.SSL.Enabled || days <= 0 {
+		return
+	}
+	block, _ := pem.Decode(config.Cfg.SSL.Certificate)
+	if block == nil {
+		return
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	left := time.Until(cert.NotAfter)
+	if left > time.Duration(days)*24*time.Hour {
+		return
+	}
+	message := "The SSL certificate of " + config.Cfg.Web.Domain +
+		" expires on " + cert.NotAfter.UTC().Format("2006-01-02 15:04")
+	if left <= 0 {
+		message = "The SSL certificate of " + config.Cfg.Web.Domain +
+			" has expired"
+	}
+	Send(newNotification(db.EVENT_CERTIFICATE, "SSL certificate expiry",
+		message, "/dashboard/ssl"))
+}
+
+// Start checks the SSL certificate every day
+func Start() {
+	go func() {
+		for {
+			checkCertificate()
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
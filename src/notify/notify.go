@@ -1,44 +1,165 @@
 package notify
 
 import (
-	"net/http"
+	"bytes"
+	"errors"
+	"log"
 	"strings"
+	"text/template"
+	"time"
 
 	"IB1/config"
 	"IB1/db"
-	"IB1/util"
 )
 
-func Notify(media string) error {
-	if config.Cfg.Media.NotificationURL == "" {
-		return nil
+// Notification is an event sent to the channels subscribed to it, its
+// fields can be used in the templates of the channels
+type Notification struct {
+	Event   db.NotificationEvent `json:"event"`
+	Site    string               `json:"site"`
+	Title   string               `json:"title"`
+	Message string               `json:"message"`
+	// Link is the page the notification is about
+	Link  string `json:"link"`
+	Board string `json:"board,omitempty"`
+	// Image is the URL of a thumbnail attached to the notification
+	Image   string    `json:"image,omitempty"`
+	Actions []Action  `json:"actions,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Action is a link the channels supporting it show as a button
+type Action struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+type sender func(db.NotificationChannel, Notification) error
+
+var senders = map[string]sender{}
+
+// Kinds returns the kinds of channels the notifications can be sent to
+func Kinds() []string {
+	return []string{"ntfy", "webhook", "gotify", "email", "matrix"}
+}
+
+func prefix() string {
+	if config.Cfg.Web.BaseURL != "" {
+		return strings.TrimSuffix(config.Cfg.Web.BaseURL, "/")
+	}
+	return "http://" + config.Cfg.Web.Domain
+}
+
+func newNotification(event db.NotificationEvent, title string,
+	message string, link string) Notification {
+	return Notification{
+		Event: event, Site: config.Cfg.Home.Title, Title: title,
+		Message: message, Link: prefix() + link, Time: time.Now(),
+	}
+}
+
+// format applies the templates of a channel to a notification
+func format(channel db.NotificationChannel, n Notification) (Notification,
+	error) {
+	for _, v := range []struct {
+		text  string
+		field *string
+	}{
+		{channel.TitleTemplate, &n.Title},
+		{channel.MessageTemplate, &n.Message},
+	} {
+		if v.text == "" {
+			continue
+		}
+		t, err := template.New("").Parse(v.text)
+		if err != nil {
+			return n, err
+		}
+		var b bytes.Buffer
+		if err := t.Execute(&b, n); err != nil {
+			return n, err
+		}
+		*v.field = b.String()
+	}
+	return n, nil
+}
+
+// Validate checks that a channel can be used
+func Validate(channel db.NotificationChannel) error {
+	if _, ok := senders[channel.Kind]; !ok {
+		return errors.New("invalid channel kind")
 	}
-	req, _ := http.NewRequest("POST", config.Cfg.Media.NotificationURL,
-		strings.NewReader("New media file on "+
-			config.Cfg.Web.Domain+" pending approval"))
-	req.Header.Set("Title", "Pending media approval")
-	secret, err := util.NewTextToken()
+	_, err := format(channel, Notification{})
+	return err
+}
+
+func send(channel db.NotificationChannel, n Notification) error {
+	f, ok := senders[channel.Kind]
+	if !ok {
+		return errors.New("invalid channel kind: " + channel.Kind)
+	}
+	n, err := format(channel, n)
 	if err != nil {
 		return err
 	}
-	prefix := "http://" + config.Cfg.Web.Domain
-	if config.Cfg.Web.BaseURL != "" {
-		prefix = config.Cfg.Web.BaseURL
-	}
-	suffix := secret + "/" + media + ".png"
-	thumbnail := prefix + "/media/thumbnail/" + suffix
-	approve := prefix + "/approval/accept/" + suffix
-	deny := prefix + "/approval/deny/" + suffix
-	req.Header.Set("Attach", thumbnail)
-	req.Header.Set("Actions",
-		"http, Approve, "+approve+", method=GET, clear=true; "+
-			"http, Deny, "+deny+", method=GET, clear=true; ")
-	_, err = http.DefaultClient.Do(req)
+	return f(channel, n)
+}
+
+// deliver sends a notification, retrying with an exponential backoff
+func deliver(channel db.NotificationChannel, n Notification) {
+	delay := 5 * time.Second
+	for attempt := 0; ; attempt++ {
+		err := send(channel, n)
+		if err == nil {
+			return
+		}
+		if attempt >= config.Cfg.Notify.Retries {
+			log.Println("notification to", channel.Name, "failed:", err)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Send sends a notification in the background to the channels subscribed
+// to its event
+func Send(n Notification) {
+	channels, err := subscribers(n.Event, n.Board)
 	if err != nil {
-		return err
+		log.Println(err)
+		return
+	}
+	for _, v := range channels {
+		go deliver(v, n)
+	}
+}
+
+// subscribers returns the channels subscribed to an event, restricted to
+// the ones following the board when it is not empty
+func subscribers(event db.NotificationEvent,
+	board string) ([]db.NotificationChannel, error) {
+	channels, err := db.GetChannels(event)
+	if err != nil || board == "" {
+		return channels, err
 	}
-	return db.ApprovalBypass{}.Add(db.ApprovalBypass{
-		Secret: secret,
-		Hash:   media,
-	})
+	b, ok := db.Boards[board]
+	if !ok {
+		return channels, nil
+	}
+	list := []db.NotificationChannel{}
+	for _, v := range channels {
+		if v.AppliesTo(b) {
+			list = append(list, v)
+		}
+	}
+	return list, nil
+}
+
+// Test sends a notification to a channel and returns the error of the
+// first attempt
+func Test(channel db.NotificationChannel) error {
+	n := newNotification("test", "Test notification",
+		"This is a test notification from "+config.Cfg.Web.Domain, "/")
+	return send(channel, n)
 }
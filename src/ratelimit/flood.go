@@ -0,0 +1,174 @@
+package ratelimit
+
+import (
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"IB1/config"
+)
+
+var errFlood = errors.New("identical content was posted recently")
+
+// Fingerprint is a content seen recently on a board, or on every board
+// when Board is empty
+type Fingerprint struct {
+	Key    string
+	Kind   string
+	Board  string
+	Sample string
+	Count  int
+	First  time.Time
+	Last   time.Time
+}
+
+func (f Fingerprint) Tripped() bool {
+	return f.Count > 1
+}
+
+type flood struct {
+	entries   map[string]Fingerprint
+	lastPurge time.Time
+	mutex     sync.Mutex
+}
+
+var Flood = flood{entries: map[string]Fingerprint{}}
+
+var quoteRegexp = regexp.MustCompile(`>>[0-9]+`)
+
+func normalize(text string) string {
+	text = quoteRegexp.ReplaceAllString(strings.ToLower(text), "")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, text)
+}
+
+func fingerprint(kind string, board string, content string) Fingerprint {
+	h := fnv.New64a()
+	h.Write([]byte(content))
+	sample := content
+	if len(sample) > 64 {
+		sample = sample[:64]
+	}
+	return Fingerprint{
+		Key:    kind + "/" + board + "/" + hex.EncodeToString(h.Sum(nil)),
+		Kind:   kind,
+		Board:  board,
+		Sample: sample,
+	}
+}
+
+func window() time.Duration {
+	return time.Duration(config.Cfg.Flood.Window) * time.Second
+}
+
+func (f *flood) purge() {
+	if time.Since(f.lastPurge) < window() {
+		return
+	}
+	for key, v := range f.entries {
+		if time.Since(v.Last) >= window() {
+			delete(f.entries, key)
+		}
+	}
+	f.lastPurge = time.Now()
+}
+
+// prints returns the fingerprints of the text and media of a post
+func (f *flood) prints(board string, text string,
+	mediaHash string) []Fingerprint {
+	if config.Cfg.Flood.SiteWide {
+		board = ""
+	}
+	prints := []Fingerprint{}
+	text = normalize(text)
+	if len(text) >= config.Cfg.Flood.MinLength && text != "" {
+		prints = append(prints, fingerprint("text", board, text))
+	}
+	if mediaHash != "" {
+		prints = append(prints, fingerprint("media", board, mediaHash))
+	}
+	return prints
+}
+
+// Check rejects a post if its text or media was posted within the flood
+// window, otherwise it reserves them so that identical posts sent at the
+// same time are rejected while it is being created
+func (f *flood) Check(board string, text string, mediaHash string) error {
+	if config.Cfg.Flood.Window <= 0 {
+		return nil
+	}
+	prints := f.prints(board, text, mediaHash)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.purge()
+	now := time.Now()
+	tripped := false
+	for _, v := range prints {
+		entry, ok := f.entries[v.Key]
+		if !ok || now.Sub(entry.Last) >= window() {
+			continue
+		}
+		entry.Count++
+		entry.Last = now
+		f.entries[v.Key] = entry
+		tripped = true
+	}
+	if tripped {
+		return errFlood
+	}
+	for _, v := range prints {
+		v.Count = 1
+		v.First = now
+		v.Last = now
+		f.entries[v.Key] = v
+	}
+	return nil
+}
+
+// Release forgets the text and media reserved by Check for a post that
+// could not be created, unless identical posts were rejected meanwhile
+func (f *flood) Release(board string, text string, mediaHash string) {
+	if config.Cfg.Flood.Window <= 0 {
+		return
+	}
+	prints := f.prints(board, text, mediaHash)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, v := range prints {
+		if entry, ok := f.entries[v.Key]; ok && !entry.Tripped() {
+			delete(f.entries, v.Key)
+		}
+	}
+}
+
+// Tripped returns the fingerprints that rejected at least one post
+func (f *flood) Tripped() []Fingerprint {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	list := []Fingerprint{}
+	for _, v := range f.entries {
+		if v.Tripped() && time.Since(v.Last) < window() {
+			list = append(list, v)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Last.After(list[j].Last)
+	})
+	return list
+}
+
+func (f *flood) Clear(key string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.entries, key)
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"IB1/config"
+)
+
+func TestFloodBurst(t *testing.T) {
+	previous := config.Cfg.Flood
+	defer func() { config.Cfg.Flood = previous }()
+	config.Cfg.Flood.Window = 60
+	config.Cfg.Flood.MinLength = 4
+	f := flood{entries: map[string]Fingerprint{}}
+
+	var accepted atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if f.Check("b", "buy cheap coins", "") == nil {
+				accepted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := accepted.Load(); n != 1 {
+		t.Fatalf("%d identical posts accepted, want 1", n)
+	}
+}
+
+func TestFloodRelease(t *testing.T) {
+	previous := config.Cfg.Flood
+	defer func() { config.Cfg.Flood = previous }()
+	config.Cfg.Flood.Window = 60
+	config.Cfg.Flood.MinLength = 4
+	f := flood{entries: map[string]Fingerprint{}}
+
+	tests := []struct {
+		name    string
+		check   []string
+		release bool
+		valid   bool
+	}{
+		{"first post", []string{"hello there", "a"}, true, true},
+		{"retry after a failure", []string{"hello there", "a"}, false, true},
+		{"duplicate", []string{"Hello, there!", "b"}, false, false},
+		{"duplicate media", []string{"other text", "a"}, false, false},
+		{"short text", []string{"hi", ""}, false, true},
+		{"short text again", []string{"hi", ""}, false, true},
+	}
+	for _, test := range tests {
+		err := f.Check("b", test.check[0], test.check[1])
+		if (err == nil) != test.valid {
+			t.Fatalf("%s: Check() = %v, want valid = %v", test.name, err,
+				test.valid)
+		}
+		if test.release {
+			f.Release("b", test.check[0], test.check[1])
+		}
+	}
+	if n := len(f.Tripped()); n != 2 {
+		t.Errorf("%d tripped fingerprints, want 2", n)
+	}
+	// a fingerprint that rejected posts is kept for the moderators
+	f.Release("b", "hello there", "a")
+	if n := len(f.Tripped()); n != 2 {
+		t.Errorf("%d tripped fingerprints after Release(), want 2", n)
+	}
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+type filesystem struct {
+	root string
+}
+
+func NewFilesystem(root string) (Storage, error) {
+	if err := os.MkdirAll(root+"/thumbnail", 0700); err != nil {
+		return nil, err
+	}
+	return filesystem{root: root}, nil
+}
+
+func (s filesystem) path(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func notFound(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s filesystem) Put(key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	// write to a temporary file first so a partial file is never served
+	f, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s filesystem) Get(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	return f, notFound(err)
+}
+
+func (s filesystem) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s filesystem) Stat(key string) (Info, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Info{}, notFound(err)
+	}
+	return Info{Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+type limitedFile struct {
+	io.Reader
+	io.Closer
+}
+
+func (s filesystem) Range(key string, offset int64,
+	length int64) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return limitedFile{io.LimitReader(f, length), f}, nil
+}
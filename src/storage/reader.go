@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+// reader reads a stored file through range requests, opening a new range
+// after every seek
+type reader struct {
+	storage Storage
+	key     string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+// NewReader returns a ReadSeeker over a stored file, for instance to serve
+// it with http.ServeContent
+func NewReader(s Storage, key string) (io.ReadSeekCloser, Info, error) {
+	info, err := s.Stat(key)
+	if err != nil {
+		return nil, info, err
+	}
+	return &reader{storage: s, key: key, size: info.Size}, info, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.storage.Range(r.key, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"IB1/config"
+)
+
+// s3 stores the files in a bucket of an S3-compatible object storage,
+// using path-style URLs so any endpoint works without DNS setup
+type s3 struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func NewS3(cfg config.S3) (Storage, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("missing S3 endpoint or bucket")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, errors.New("invalid S3 endpoint")
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return s3{
+		endpoint:  endpoint,
+		region:    region,
+		bucket:    cfg.Bucket,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		client: &http.Client{
+			Transport: &http.Transport{},
+			Timeout:   time.Minute,
+		},
+	}, nil
+}
+
+// escape encodes a path as required by the signature, leaving only the
+// unreserved characters and the slashes
+func escape(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+			'0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sign adds an AWS signature version 4 made at a time to the request
+func (s s3) sign(req *http.Request, payload []byte, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	stamp := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(payload)
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + stamp,
+		"",
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" +
+		sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+
+		s.accessKey+"/"+scope+", SignedHeaders="+signed+
+		", Signature="+hex.EncodeToString(hmacSHA256(key, toSign)))
+}
+
+func (s s3) request(method string, key string, payload []byte,
+	header http.Header) (*http.Response, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+	u := *s.endpoint
+	path := u.Path + "/" + s.bucket + "/" + key
+	u.Path = path
+	u.RawPath = escape(path)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, payload, time.Now())
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, ErrNotFound
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		res.Body.Close()
+		return nil, errors.New("S3 request failed: " + res.Status)
+	}
+	return res, nil
+}
+
+func (s s3) Put(key string, r io.Reader) error {
+	// the payload is signed so it has to be read entirely, media being
+	// bounded by the maximum upload size
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	res, err := s.request("PUT", key, payload, nil)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (s s3) Get(key string) (io.ReadCloser, error) {
+	res, err := s.request("GET", key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (s s3) Delete(key string) error {
+	res, err := s.request("DELETE", key, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (s s3) Stat(key string) (Info, error) {
+	res, err := s.request("HEAD", key, nil, nil)
+	if err != nil {
+		return Info{}, err
+	}
+	res.Body.Close()
+	modTime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return Info{Size: res.ContentLength, ModTime: modTime}, nil
+}
+
+func (s s3) Range(key string, offset int64,
+	length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	bytesRange := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+	if length > 0 {
+		bytesRange += strconv.FormatInt(offset+length-1, 10)
+	}
+	res, err := s.request("GET", key, nil,
+		http.Header{"Range": []string{bytesRange}})
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusPartialContent {
+		return res.Body, nil
+	}
+	// the whole file was sent, skip to the requested range
+	if _, err := io.CopyN(io.Discard, res.Body, offset); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return res.Body, nil
+	}
+	return limitedFile{io.LimitReader(res.Body, length), res.Body}, nil
+}
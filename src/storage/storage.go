@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"IB1/config"
+)
+
+var ErrNotFound = errors.New("file not found")
+
+var errInvalidKey = errors.New("invalid file name")
+
+// Info describes a stored file
+type Info struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage keeps the files of the media and of their thumbnails, a key
+// being "<hash>.<extension>" for a media, "thumbnail/<hash>.<extension>"
+// for a thumbnail and "thumbnail/reply/<hash>.<extension>" for the
+// thumbnail of the replies
+type Storage interface {
+	Put(key string, r io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	Stat(key string) (Info, error)
+	// Range reads length bytes from offset, or up to the end of the file
+	// if length is negative
+	Range(key string, offset int64, length int64) (io.ReadCloser, error)
+}
+
+// Target is a backend and the settings it is opened with
+type Target struct {
+	Backend string
+	Path    string
+	S3      config.S3
+}
+
+// Same reports whether two targets point to the same files, whatever the
+// credentials used to access them
+func (t Target) Same(other Target) bool {
+	if t.Backend != other.Backend {
+		return false
+	}
+	switch t.Backend {
+	case "filesystem":
+		return filepath.Clean(t.Path) == filepath.Clean(other.Path)
+	case "s3":
+		return strings.TrimSuffix(t.S3.Endpoint, "/") ==
+			strings.TrimSuffix(other.S3.Endpoint, "/") &&
+			t.S3.Bucket == other.S3.Bucket
+	}
+	return true
+}
+
+var backends = map[string]func(Target) (Storage, error){
+	"filesystem": func(t Target) (Storage, error) {
+		return NewFilesystem(t.Path)
+	},
+	"s3": func(t Target) (Storage, error) {
+		return NewS3(t.S3)
+	},
+}
+
+var current struct {
+	storage Storage
+	lock    sync.RWMutex
+}
+
+// Media returns the storage currently used
+func Media() Storage {
+	current.lock.RLock()
+	defer current.lock.RUnlock()
+	return current.storage
+}
+
+// SetMedia replaces the storage used, the requests being served while it
+// is replaced
+func SetMedia(s Storage) {
+	current.lock.Lock()
+	defer current.lock.Unlock()
+	current.storage = s
+}
+
+func Register(name string, f func(Target) (Storage, error)) {
+	backends[name] = f
+}
+
+func Backends() []string {
+	return []string{"database", "filesystem", "s3"}
+}
+
+// Current returns the name of the backend selected in the configuration
+func Current() string {
+	if config.Cfg.Media.Storage != "" {
+		return config.Cfg.Media.Storage
+	}
+	if config.Cfg.Media.InDatabase {
+		return "database"
+	}
+	return "filesystem"
+}
+
+// Configured returns the target selected in the configuration
+func Configured() Target {
+	return Target{
+		Backend: Current(), Path: config.Cfg.Media.Path,
+		S3: config.Cfg.Media.S3,
+	}
+}
+
+func Open(t Target) (Storage, error) {
+	f, ok := backends[t.Backend]
+	if !ok {
+		return nil, errors.New("unknown storage backend")
+	}
+	return f(t)
+}
+
+func Load() error {
+	v, err := Open(Configured())
+	if err != nil {
+		return err
+	}
+	SetMedia(v)
+	return nil
+}
+
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") ||
+		path.Clean(key) != key || strings.HasPrefix(key, "..") {
+		return errInvalidKey
+	}
+	return nil
+}
+
+// Copy copies a file from a storage to another
+func Copy(src Storage, dst Storage, key string) error {
+	r, err := src.Get(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return dst.Put(key, r)
+}
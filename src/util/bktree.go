@@ -0,0 +1,69 @@
+package util
+
+import (
+	"math/bits"
+)
+
+// BKTree indexes 64-bit hashes by their hamming distance
+type BKTree[T any] struct {
+	root *bkNode[T]
+}
+
+type bkNode[T any] struct {
+	hash     uint64
+	values   []T
+	children map[int]*bkNode[T]
+}
+
+func distance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func (t *BKTree[T]) Insert(hash uint64, value T) {
+	if t.root == nil {
+		t.root = &bkNode[T]{hash: hash, values: []T{value}}
+		return
+	}
+	node := t.root
+	for {
+		d := distance(node.hash, hash)
+		if d == 0 {
+			node.values = append(node.values, value)
+			return
+		}
+		child, ok := node.children[d]
+		if !ok {
+			if node.children == nil {
+				node.children = map[int]*bkNode[T]{}
+			}
+			node.children[d] = &bkNode[T]{
+				hash: hash, values: []T{value},
+			}
+			return
+		}
+		node = child
+	}
+}
+
+// Search returns the values of every hash at most radius bits away
+func (t *BKTree[T]) Search(hash uint64, radius int) []T {
+	found := []T{}
+	if t.root == nil || radius < 0 {
+		return found
+	}
+	nodes := []*bkNode[T]{t.root}
+	for len(nodes) > 0 {
+		node := nodes[len(nodes)-1]
+		nodes = nodes[:len(nodes)-1]
+		d := distance(node.hash, hash)
+		if d <= radius {
+			found = append(found, node.values...)
+		}
+		for k, child := range node.children {
+			if k >= d-radius && k <= d+radius {
+				nodes = append(nodes, child)
+			}
+		}
+	}
+	return found
+}
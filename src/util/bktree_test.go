@@ -0,0 +1,84 @@
+package util
+
+import (
+	"math/bits"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestBKTree(t *testing.T) {
+	var tree BKTree[string]
+	if got := tree.Search(0, 64); len(got) != 0 {
+		t.Fatalf("Search() on an empty tree = %v", got)
+	}
+	hashes := []struct {
+		hash  uint64
+		value string
+	}{
+		{0x0000000000000000, "zero"},
+		{0x0000000000000001, "one bit"},
+		{0x0000000000000003, "two bits"},
+		{0x00000000000000ff, "eight bits"},
+		{0xffffffffffffffff, "all bits"},
+		{0x0000000000000001, "one bit again"},
+	}
+	for _, v := range hashes {
+		tree.Insert(v.hash, v.value)
+	}
+
+	tests := []struct {
+		hash   uint64
+		radius int
+		want   []string
+	}{
+		{0, 0, []string{"zero"}},
+		{0, 1, []string{"one bit", "one bit again", "zero"}},
+		{0, 2, []string{"one bit", "one bit again", "two bits", "zero"}},
+		{0x01, 0, []string{"one bit", "one bit again"}},
+		{0x0f, 4, []string{"eight bits", "one bit", "one bit again",
+			"two bits", "zero"}},
+		{0xffffffffffffff00, 8, []string{"all bits"}},
+		{0xffffffffffffff00, 7, []string{}},
+		{0, -1, []string{}},
+	}
+	for _, test := range tests {
+		got := tree.Search(test.hash, test.radius)
+		slices.Sort(got)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("Search(%#x, %d) = %v, want %v", test.hash,
+				test.radius, got, test.want)
+		}
+	}
+}
+
+func TestBKTreeLinearScan(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var tree BKTree[int]
+	hashes := make([]uint64, 2000)
+	for i := range hashes {
+		hashes[i] = r.Uint64()
+		// near duplicates exercise the short distances
+		if i%4 == 0 && i > 0 {
+			hashes[i] = hashes[i-1] ^ 1<<(r.Intn(64))
+		}
+		tree.Insert(hashes[i], i)
+	}
+	for _, radius := range []int{0, 1, 5, 20, 30} {
+		for q := 0; q < 50; q++ {
+			query := hashes[r.Intn(len(hashes))] ^ r.Uint64()&r.Uint64()
+			want := []int{}
+			for i, hash := range hashes {
+				if bits.OnesCount64(hash^query) <= radius {
+					want = append(want, i)
+				}
+			}
+			got := tree.Search(query, radius)
+			slices.Sort(got)
+			if !slices.Equal(got, want) {
+				t.Fatalf("Search(%#x, %d) = %v, want %v", query, radius,
+					got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package web
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"IB1/db"
+	"IB1/media"
+)
+
+const approvalPerPage = 24
+
+type approvalQueue struct {
+	Medias []db.MediaEntry
+	Count  int64
+	Page   int
+	Pages  int
+	// previous and next pages, 0 when there is none
+	Previous int
+	Next     int
+	// whether the pending media of every board are listed
+	Global bool
+}
+
+func approval(c echo.Context) error {
+	user, err := loggedAs(c)
+	if err != nil {
+		return err
+	}
+	boards, err := user.ApprovalBoards()
+	if err != nil {
+		return err
+	}
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	queue := approvalQueue{Page: page, Global: boards == nil}
+	queue.Medias, queue.Count, err = db.GetMedias(db.MediaFilter{
+		State: "pending", BoardIDs: boards, OldestFirst: true,
+	}, page-1, approvalPerPage)
+	if err != nil {
+		return err
+	}
+	queue.Pages = int((queue.Count + approvalPerPage - 1) / approvalPerPage)
+	if page > 1 {
+		queue.Previous = page - 1
+	}
+	if page < queue.Pages {
+		queue.Next = page + 1
+	}
+	return render("approval.html", queue, c)
+}
+
+// approver only lets through the members who can approve media, globally
+// or on some of their boards
+func approver(f echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := loggedAs(c)
+		if err != nil {
+			return err
+		}
+		if _, err := user.ApprovalBoards(); err != nil {
+			return err
+		}
+		return f(c)
+	}
+}
+
+// selectedMedia returns the media selected in the approval queue after
+// checking that the member can approve each of them
+func selectedMedia(c echo.Context) (db.Account, []string, error) {
+	user, err := loggedAs(c)
+	if err != nil {
+		return db.Account{}, nil, err
+	}
+	form, err := c.FormParams()
+	if err != nil {
+		return db.Account{}, nil, err
+	}
+	hashes := []string{}
+	for _, v := range form["media"] {
+		hash := strings.Split(v, ".")[0]
+		if err := user.CanApprove(hash); err != nil {
+			return db.Account{}, nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	if len(hashes) == 0 {
+		return db.Account{}, nil, errors.New("no media selected")
+	}
+	return user, hashes, nil
+}
+
+func approveMedia(c echo.Context) error {
+	if c.Request().Method == "GET" {
+		err := db.ApprovalBypass{}.Delete(c.Param("secret"))
+		if err != nil {
+			return err
+		}
+		hash := strings.Split(c.Param("hash"), ".")[0]
+		return db.Approve(hash, "notification link")
+	}
+	user, hashes, err := selectedMedia(c)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if err := db.Approve(hash, user.Name); err != nil {
+			return err
+		}
+	}
+	return db.AddModLog(user, 0, "media approve",
+		strconv.Itoa(len(hashes))+" media from the approval queue")
+}
+
+func approveAll(c echo.Context) error {
+	user, err := loggedAs(c)
+	if err != nil {
+		return err
+	}
+	if err := db.ApproveAll(user.Name); err != nil {
+		return err
+	}
+	return db.AddModLog(user, 0, "media approve",
+		"every media of the approval queue")
+}
+
+// deny removes a media and tells the posters why
+func deny(hash string, reason string) error {
+	posts, err := db.DenyMedia(hash)
+	if err != nil {
+		return err
+	}
+	message := "The media of your post "
+	for _, v := range posts {
+		text := message + "/" + v.Board.Name + "/" +
+			strconv.Itoa(v.Number) + " was denied"
+		if reason != "" {
+			text += ": " + reason
+		}
+		notifySession(v.Session, text)
+	}
+	return nil
+}
+
+func denyMedia(c echo.Context) error {
+	if c.Request().Method == "GET" {
+		db.ApprovalBypass{}.Delete(c.Param("secret"))
+		return deny(strings.Split(c.Param("hash"), ".")[0], "")
+	}
+	user, hashes, err := selectedMedia(c)
+	if err != nil {
+		return err
+	}
+	reason, _ := getPostForm(c, "reason")
+	reason = strings.TrimSpace(reason)
+	for _, hash := range hashes {
+		if err := deny(hash, reason); err != nil {
+			return err
+		}
+	}
+	return db.AddModLog(user, 0, "media deny",
+		strconv.Itoa(len(hashes))+" media from the approval queue: "+
+			reason)
+}
+
+func banPendingMedia(c echo.Context) error {
+	user, hashes, err := selectedMedia(c)
+	if err != nil {
+		return err
+	}
+	reason, _ := getPostForm(c, "reason")
+	reason = strings.TrimSpace(reason)
+	for _, hash := range hashes {
+		if err := media.Ban(hash); err != nil {
+			return err
+		}
+		if err := deny(hash, reason); err != nil {
+			return err
+		}
+	}
+	return db.AddModLog(user, 0, "media ban",
+		strconv.Itoa(len(hashes))+" media from the approval queue: "+
+			reason)
+}
@@ -0,0 +1,71 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"IB1/config"
+	"IB1/db"
+)
+
+// immutableCache is sent with the files whose URL changes with their
+// content, the media being addressed by their hash and the stylesheets by
+// their fingerprint, unlike the thumbnails
+const immutableCache = "public, max-age=31536000, immutable"
+
+func fingerprint(data []byte) string {
+	h := fnv.New64a()
+	h.Write(data)
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
+// noStore prevents the images served in place of a media from being cached
+// as the media itself
+func noStore(c echo.Context) {
+	c.Response().Header().Set("Cache-Control", "no-store")
+}
+
+// mediaCacheControl returns how the files of a media can be cached, the
+// files of a media waiting for approval only being kept by the browser of
+// the members allowed to see them
+func mediaCacheControl(media db.Media) string {
+	if config.Cfg.Media.ApprovalQueue && !media.Approved {
+		return "private, no-cache"
+	}
+	return immutableCache
+}
+
+// serveMedia serves a file with an ETag, the file being cached for good if
+// it is requested with its fingerprint
+func serveMedia(c echo.Context, data []byte, name string) {
+	v := fingerprint(data)
+	header := c.Response().Header()
+	header.Set("ETag", `"`+v+`"`)
+	if header.Get("Cache-Control") == "" {
+		if c.QueryParam("v") == v {
+			header.Set("Cache-Control", immutableCache)
+		} else {
+			header.Set("Cache-Control", "no-cache")
+		}
+	}
+	http.ServeContent(c.Response().Writer, c.Request(), name, time.Time{},
+		bytes.NewReader(data))
+}
+
+// asset returns the fingerprinted URL of a stylesheet
+func asset(name string) string {
+	getThemes()
+	v, ok := themesFingerprint[name]
+	if name == "common.css" {
+		v, ok = stylesheetFingerprint, true
+	}
+	if !ok {
+		return "/static/" + name
+	}
+	return "/static/" + name + "?v=" + v
+}
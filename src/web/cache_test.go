@@ -0,0 +1,50 @@
+package web
+
+import (
+	"strings"
+	"testing"
+
+	"IB1/db"
+)
+
+func TestAsset(t *testing.T) {
+	if err := minifyStylesheet(); err != nil {
+		t.Fatal(err)
+	}
+	if err := createTheme([]byte("body { color: red; }"), "fingerprinted",
+		true); err != nil {
+		t.Fatal(err)
+	}
+	themes, err := db.Theme{}.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id int
+	for _, v := range themes {
+		if v.Name == "fingerprinted" {
+			id = int(v.ID)
+		}
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"common.css", "/static/common.css?v=" + fingerprint(stylesheet)},
+		{"fingerprinted.css", "/static/fingerprinted.css?v=" +
+			fingerprint(themesContent["fingerprinted.css"])},
+		{"missing.css", "/static/missing.css"},
+	}
+	for _, test := range tests {
+		if got := asset(test.name); got != test.want {
+			t.Errorf("asset(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	if err := updateTheme(id, "fingerprinted", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := asset("fingerprinted.css"); strings.Contains(got, "?v=") {
+		t.Errorf("asset() of a disabled theme = %q", got)
+	}
+}
@@ -1,8 +1,13 @@
 package web
 
 import (
+	"encoding/json"
 	"errors"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dchest/captcha"
@@ -14,13 +19,94 @@ import (
 
 var captchaStore captcha.Store
 
+var captchaLanguages = []string{"en", "ja", "pt", "ru", "zh"}
+
+var errWrongCaptcha = errors.New("wrong captcha")
+
+// captchaProvider verifies the answer given to a captcha, the form being
+// rendered by the "captcha" template according to the provider name
+type captchaProvider interface {
+	Verify(c echo.Context) error
+}
+
+var captchaProviders = map[string]captchaProvider{
+	"none":     noCaptcha{},
+	"image":    imageCaptcha{},
+	"question": questionCaptcha{},
+	"external": externalCaptcha{},
+}
+
+var captchaProviderNames = []string{"none", "image", "question", "external"}
+
 func captchaInit() {
 	captchaStore = captcha.NewMemoryStore(4096, time.Hour)
 	captcha.SetCustomStore(captchaStore)
 }
 
+// getCaptchaProvider returns the name of the provider used for a kind of
+// form ("login", "registration", "thread" or "post"), or an empty string
+// if no captcha is required
+func getCaptchaProvider(c echo.Context, kind string) string {
+	cfg := config.Cfg.Captcha
+	if !cfg.Enabled {
+		return ""
+	}
+	provider := cfg.Provider
+	switch kind {
+	case "login":
+		if !cfg.Login {
+			return ""
+		}
+	case "registration":
+		if !cfg.Registration {
+			return ""
+		}
+	case "thread", "post":
+		if kind == "thread" && !cfg.Thread ||
+			kind == "post" && !cfg.Post {
+			return ""
+		}
+		// trusted users don't need captcha
+		if user, err := loggedAs(c); err == nil {
+			if err := user.Can(db.BYPASS_CAPTCHA); err == nil {
+				return ""
+			}
+		}
+		board, err := db.GetBoard(c.Param("board"))
+		if err == nil && board.Captcha != "" {
+			provider = board.Captcha
+		}
+	default:
+		return ""
+	}
+	if _, ok := captchaProviders[provider]; !ok || provider == "none" {
+		return ""
+	}
+	return provider
+}
+
+func checkCaptcha(c echo.Context, kind string) error {
+	provider := getCaptchaProvider(c, kind)
+	if provider == "" {
+		return nil
+	}
+	return captchaProviders[provider].Verify(c)
+}
+
+type noCaptcha struct{}
+
+func (noCaptcha) Verify(echo.Context) error {
+	return nil
+}
+
+type imageCaptcha struct{}
+
 func captchaNew(c echo.Context) (string, error) {
-	captchaID := captcha.New()
+	length := config.Cfg.Captcha.Length
+	if length <= 0 {
+		length = captcha.DefaultLen
+	}
+	captchaID := captcha.NewLen(length)
 	digits := captchaStore.Get(captchaID, false)
 	for i := range digits {
 		digits[i] += byte('0')
@@ -43,37 +129,283 @@ func captchaImage(c echo.Context) error {
 		captcha.StdWidth, captcha.StdHeight)
 }
 
-func captchaVerify(c echo.Context, answer string) bool {
-	v := get(c)("captcha-need")
-	if v == nil {
+func captchaAudio(c echo.Context) error {
+	id, ok := get(c)("captcha").(string)
+	if !ok || captchaStore.Get(id, false) == nil {
+		var err error
+		id, err = captchaNew(c)
+		if err != nil {
+			return badRequest(c, err)
+		}
+	}
+	c.Response().Header().Set("Content-Type", "audio/wav")
+	c.Response().WriteHeader(http.StatusOK)
+	return captcha.WriteAudio(c.Response().Writer, id,
+		config.Cfg.Captcha.AudioLanguage)
+}
+
+func (imageCaptcha) Verify(c echo.Context) error {
+	answer, ok := getPostForm(c, "captcha")
+	if !ok {
+		return errInvalidForm
+	}
+	v, ok := get(c)("captcha-need").(string)
+	if !ok || v != answer {
+		return errWrongCaptcha
+	}
+	return nil
+}
+
+type questionCaptcha struct{}
+
+// captchaQuestion picks a random question and remembers it in the session
+// until it is answered
+func captchaQuestion(c echo.Context) string {
+	questions, err := db.CaptchaQuestion{}.GetAll()
+	if err != nil || len(questions) == 0 {
+		return ""
+	}
+	question := questions[rand.IntN(len(questions))]
+	set(c)("captcha-question",
+		strconv.FormatUint(uint64(question.ID), 10))
+	return question.Question
+}
+
+func (questionCaptcha) Verify(c echo.Context) error {
+	answer, ok := getPostForm(c, "captcha")
+	if !ok {
+		return errInvalidForm
+	}
+	v, _ := once(c)("captcha-question").(string)
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return errWrongCaptcha
+	}
+	question, err := db.GetCaptchaQuestion(uint(id))
+	if err != nil || !question.Accepts(answer) {
+		return errWrongCaptcha
+	}
+	return nil
+}
+
+// externalCaptcha asks a verification service compatible with hCaptcha,
+// reCAPTCHA and Turnstile whether the response of its widget is valid
+type externalCaptcha struct{}
+
+// form fields used by the widgets of the supported services
+var externalCaptchaFields = []string{
+	"h-captcha-response", "g-recaptcha-response",
+	"cf-turnstile-response", "captcha",
+}
+
+var externalCaptchaClient = &http.Client{
+	Transport: &http.Transport{Proxy: http.ProxyFromEnvironment},
+	Timeout:   10 * time.Second,
+}
+
+func (externalCaptcha) Verify(c echo.Context) error {
+	external := config.Cfg.Captcha.External
+	if external.VerifyURL == "" {
+		return errors.New("captcha verification is not configured")
+	}
+	response := ""
+	for _, field := range externalCaptchaFields {
+		if v, ok := getPostForm(c, field); ok && v != "" {
+			response = v
+			break
+		}
+	}
+	if response == "" {
+		return errWrongCaptcha
+	}
+	resp, err := externalCaptchaClient.PostForm(external.VerifyURL,
+		url.Values{
+			"secret":   {external.Secret},
+			"response": {response},
+			"remoteip": {clientIP(c)},
+			"sitekey":  {external.SiteKey},
+		})
+	if err != nil {
+		return errors.New("captcha verification failed")
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Success bool `json:"success"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return errors.New("captcha verification failed")
+	}
+	if !result.Success {
+		return errWrongCaptcha
+	}
+	return nil
+}
+
+// validOrigin reports whether s can be added to a Content-Security-Policy
+// as a source, "https://*.example.com" being accepted
+func validOrigin(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" || u.Path != "" || u.RawQuery != "" ||
+		u.User != nil || (u.Scheme != "https" && u.Scheme != "http") {
 		return false
 	}
-	return v.(string) == answer
+	return !strings.ContainsAny(s, ";,'\"\\ ")
 }
 
-func checkCaptcha(c echo.Context) error {
-	if !config.Cfg.Captcha.Enabled {
-		return nil
+// externalCaptchaSources returns the origins the widget of the external
+// provider is loaded from, empty if the provider has no widget
+func externalCaptchaSources() string {
+	cfg := config.Cfg.Captcha
+	if !cfg.Enabled || cfg.External.Script == "" {
+		return ""
+	}
+	u, err := url.Parse(cfg.External.Script)
+	if err != nil || !validOrigin(u.Scheme+"://"+u.Host) {
+		return ""
 	}
-	// trusted users don't need captcha
-	if user, err := loggedAs(c); err == nil {
-		if err := user.Can(db.BYPASS_CAPTCHA); err == nil {
-			return nil
+	sources := []string{u.Scheme + "://" + u.Host}
+	for _, v := range strings.Fields(cfg.External.Origins) {
+		if validOrigin(v) {
+			sources = append(sources, v)
 		}
 	}
-	return verifyCaptcha(c)
+	return strings.Join(sources, " ")
 }
 
-func verifyCaptcha(c echo.Context) error {
-	if !config.Cfg.Captcha.Enabled {
-		return nil
+func updateCaptcha(c echo.Context) error {
+	tmp := config.Cfg.Captcha
+
+	provider, _ := getPostForm(c, "provider")
+	if _, ok := captchaProviders[provider]; !ok {
+		return errors.New("invalid captcha provider")
 	}
-	captcha, hasCaptcha := getPostForm(c, "captcha")
-	if !hasCaptcha {
-		return errors.New("invalid form")
+	tmp.Provider = provider
+
+	length, err := getInt(c, "length")
+	if err != nil {
+		return err
 	}
-	if !captchaVerify(c, captcha) {
-		return errors.New("wrong captcha")
+	if length < 1 || length > 20 {
+		return errors.New("invalid captcha length")
+	}
+	tmp.Length = length
+
+	toggles := map[string]*bool{
+		"login":        &tmp.Login,
+		"registration": &tmp.Registration,
+		"thread":       &tmp.Thread,
+		"post":         &tmp.Post,
+	}
+	for param, v := range toggles {
+		value, _ := getPostForm(c, param)
+		*v = value == "on"
+	}
+
+	tmp.External.VerifyURL, _ = getPostForm(c, "verify-url")
+	tmp.External.SiteKey, _ = getPostForm(c, "site-key")
+	tmp.External.Script, _ = getPostForm(c, "script")
+	tmp.External.Class, _ = getPostForm(c, "class")
+	tmp.External.Origins, _ = getPostForm(c, "origins")
+	if tmp.External.Script != "" {
+		u, err := url.Parse(tmp.External.Script)
+		if err != nil || !validOrigin(u.Scheme+"://"+u.Host) {
+			return errors.New("invalid widget script URL")
+		}
+	}
+	for _, v := range strings.Fields(tmp.External.Origins) {
+		if !validOrigin(v) {
+			return errors.New("invalid origin: " + v)
+		}
+	}
+	if secret, _ := getPostForm(c, "secret"); secret != "" {
+		tmp.External.Secret = secret
+	}
+	if tmp.Provider == "external" && tmp.External.VerifyURL == "" {
+		return errors.New("the external provider needs a verification URL")
+	}
+	if tmp.Provider == "question" {
+		if err := hasCaptchaQuestions(); err != nil {
+			return err
+		}
+	}
+
+	config.Cfg.Captcha = tmp
+	return db.UpdateConfig()
+}
+
+func newCaptchaQuestion(question, answers string) (db.CaptchaQuestion,
+	error) {
+	if strings.TrimSpace(question) == "" ||
+		strings.Trim(answers, " ,") == "" {
+		return db.CaptchaQuestion{},
+			errors.New("a question needs at least one answer")
+	}
+	return db.CaptchaQuestion{Question: question, Answers: answers}, nil
+}
+
+func createCaptchaQuestion(question, answers string) error {
+	v, err := newCaptchaQuestion(question, answers)
+	if err != nil {
+		return err
+	}
+	return db.CaptchaQuestion{}.Add(v)
+}
+
+// hasCaptchaQuestions refuses the question provider when there is no
+// question to ask, every answer being rejected otherwise
+func hasCaptchaQuestions() error {
+	count, err := db.CountCaptchaQuestions()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("the question provider needs at least one " +
+			"question")
 	}
 	return nil
 }
+
+// questionProviderUsed checks if the question provider is used globally
+// or by a board
+func questionProviderUsed() (bool, error) {
+	if config.Cfg.Captcha.Provider == "question" {
+		return true, nil
+	}
+	boards, err := db.GetBoards()
+	if err != nil {
+		return false, err
+	}
+	for _, v := range boards {
+		if v.Captcha == "question" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func deleteCaptchaQuestion(id int) error {
+	count, err := db.CountCaptchaQuestions()
+	if err != nil {
+		return err
+	}
+	if count <= 1 {
+		used, err := questionProviderUsed()
+		if err != nil {
+			return err
+		}
+		if used {
+			return errors.New("the last question cannot be deleted " +
+				"while the question provider is used")
+		}
+	}
+	return db.CaptchaQuestion{}.RemoveID(id, db.CaptchaQuestion{})
+}
+
+func updateCaptchaQuestion(id int, question, answers string) error {
+	v, err := newCaptchaQuestion(question, answers)
+	if err != nil {
+		return err
+	}
+	return db.CaptchaQuestion{}.Update(id, v)
+}
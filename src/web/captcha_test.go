@@ -0,0 +1,188 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"IB1/config"
+	"IB1/db"
+)
+
+func TestExternalCaptchaVerify(t *testing.T) {
+	var received url.Values
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			received = r.PostForm
+			switch r.PostForm.Get("response") {
+			case "valid":
+				w.Write([]byte(`{"success": true}`))
+			case "broken":
+				w.WriteHeader(http.StatusInternalServerError)
+			default:
+				w.Write([]byte(`{"success": false}`))
+			}
+		}))
+	defer server.Close()
+
+	previous := config.Cfg.Captcha
+	defer func() { config.Cfg.Captcha = previous }()
+	config.Cfg.Captcha.External.VerifyURL = server.URL
+	config.Cfg.Captcha.External.Secret = "secret"
+	config.Cfg.Captcha.External.SiteKey = "site"
+
+	tests := []struct {
+		name  string
+		form  url.Values
+		valid bool
+	}{
+		{"hcaptcha", url.Values{"h-captcha-response": {"valid"}}, true},
+		{"recaptcha", url.Values{"g-recaptcha-response": {"valid"}}, true},
+		{"turnstile", url.Values{"cf-turnstile-response": {"valid"}}, true},
+		{"plain field", url.Values{"captcha": {"valid"}}, true},
+		{"rejected", url.Values{"h-captcha-response": {"wrong"}}, false},
+		{"server error", url.Values{"captcha": {"broken"}}, false},
+		{"no response", url.Values{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			received = nil
+			req := httptest.NewRequest("POST", "/",
+				strings.NewReader(test.form.Encode()))
+			req.Header.Set("Content-Type",
+				"application/x-www-form-urlencoded")
+			req.RemoteAddr = "192.0.2.1:1234"
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+			err := externalCaptcha{}.Verify(c)
+			if (err == nil) != test.valid {
+				t.Fatalf("Verify() = %v, want valid = %v", err, test.valid)
+			}
+			if received == nil {
+				return
+			}
+			if received.Get("secret") != "secret" ||
+				received.Get("sitekey") != "site" ||
+				received.Get("remoteip") != "192.0.2.1" {
+				t.Errorf("unexpected verification request: %v", received)
+			}
+		})
+	}
+}
+
+func TestExternalCaptchaUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	address := server.URL
+	server.Close()
+
+	previous := config.Cfg.Captcha
+	defer func() { config.Cfg.Captcha = previous }()
+	config.Cfg.Captcha.External.VerifyURL = address
+
+	req := httptest.NewRequest("POST", "/",
+		strings.NewReader("captcha=valid"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	if err := (externalCaptcha{}).Verify(c); err == nil {
+		t.Fatal("Verify() succeeded without a verification service")
+	}
+}
+
+func TestExternalCaptchaSources(t *testing.T) {
+	previous := config.Cfg.Captcha
+	defer func() { config.Cfg.Captcha = previous }()
+
+	tests := []struct {
+		script  string
+		origins string
+		want    string
+	}{
+		{"", "", ""},
+		{"https://js.hcaptcha.com/1/api.js", "https://*.hcaptcha.com",
+			"https://js.hcaptcha.com https://*.hcaptcha.com"},
+		{"https://challenges.cloudflare.com/turnstile/v0/api.js", "",
+			"https://challenges.cloudflare.com"},
+		{"https://example.com/api.js", "https://a.com;script-src * 'x'",
+			"https://example.com"},
+		{"javascript:alert(1)", "", ""},
+	}
+	for _, test := range tests {
+		config.Cfg.Captcha.Enabled = true
+		config.Cfg.Captcha.External.Script = test.script
+		config.Cfg.Captcha.External.Origins = test.origins
+		if got := externalCaptchaSources(); got != test.want {
+			t.Errorf("externalCaptchaSources() with %q, %q = %q, want %q",
+				test.script, test.origins, got, test.want)
+		}
+	}
+}
+
+func TestQuestionProvider(t *testing.T) {
+	previous := config.Cfg.Captcha
+	defer func() { config.Cfg.Captcha = previous }()
+	config.Cfg.Captcha.Provider = "image"
+	if err := db.CreateBoard("question", "", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.LoadBoards(); err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(provider string) error {
+		form := url.Values{"provider": {provider}, "length": {"6"}}
+		req := httptest.NewRequest("POST", "/",
+			strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+		return updateCaptcha(c)
+	}
+	questionID := func() int {
+		questions, err := db.CaptchaQuestion{}.GetAll()
+		if err != nil || len(questions) == 0 {
+			t.Fatal("no question", err)
+		}
+		return int(questions[0].ID)
+	}
+
+	steps := []struct {
+		name  string
+		run   func() error
+		valid bool
+	}{
+		{"provider without questions", func() error {
+			return update("question")
+		}, false},
+		{"add a question", func() error {
+			return createCaptchaQuestion("2+2?", "4, four")
+		}, true},
+		{"provider with a question", func() error {
+			return update("question")
+		}, true},
+		{"delete the last question", func() error {
+			return deleteCaptchaQuestion(questionID())
+		}, false},
+		{"other provider", func() error {
+			return update("image")
+		}, true},
+		{"delete an unused question", func() error {
+			return deleteCaptchaQuestion(questionID())
+		}, true},
+		{"board provider without questions", func() error {
+			board := db.Boards["question"]
+			return setBoard(board.ID, board.Name, board.LongName, "", "",
+				false, false, false, false, false, "question", false)
+		}, false},
+	}
+	for _, step := range steps {
+		if err := step.run(); (err == nil) != step.valid {
+			t.Fatalf("%s: %v, want valid = %v", step.name, err, step.valid)
+		}
+	}
+	if config.Cfg.Captcha.Provider != "image" {
+		t.Errorf("provider = %q, want image", config.Cfg.Captcha.Provider)
+	}
+}
@@ -4,17 +4,20 @@ import (
 	"IB1/acme"
 	"IB1/config"
 	"IB1/db"
+	"IB1/media"
 	"IB1/ratelimit"
+	"IB1/storage"
 	"context"
 	"errors"
 	"io"
 	"math/rand"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
+	"slices"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -124,6 +127,12 @@ func updateConfig(c echo.Context) error {
 	captcha, _ := getPostForm(c, "captcha")
 	config.Cfg.Captcha.Enabled = captcha == "on"
 
+	language, _ := getPostForm(c, "captcha-language")
+	if !slices.Contains(captchaLanguages, language) {
+		return errors.New("invalid captcha language")
+	}
+	config.Cfg.Captcha.AudioLanguage = language
+
 	ascii, _ := getPostForm(c, "ascii")
 	config.Cfg.Post.AsciiOnly = ascii == "on"
 
@@ -164,14 +173,9 @@ func updateConfig(c echo.Context) error {
 
 func updateMedia(c echo.Context) error {
 
-	indb, _ := getPostForm(c, "indb")
-	v := indb == "on"
-	requireRestart := v != config.Cfg.Media.InDatabase
-	config.Cfg.Media.InDatabase = v
-
 	approval, _ := getPostForm(c, "approval")
-	v = approval == "on"
-	requireRestart = v != config.Cfg.Media.ApprovalQueue || requireRestart
+	v := approval == "on"
+	requireRestart := v != config.Cfg.Media.ApprovalQueue
 	config.Cfg.Media.ApprovalQueue = v
 
 	tmp, ok := getPostForm(c, "tmp")
@@ -184,17 +188,45 @@ func updateMedia(c echo.Context) error {
 	}
 	config.Cfg.Media.Tmp = tmp
 
+	backend, _ := getPostForm(c, "storage")
+	if !slices.Contains(storage.Backends(), backend) {
+		return errInvalidForm
+	}
 	path, _ := getPostForm(c, "media")
 	if path == "" {
 		path = config.Cfg.Media.Path
 	}
-	if !config.Cfg.Media.InDatabase {
-		err = os.MkdirAll(path+"/thumbnail", 0700)
-	}
-	if err != nil {
-		return err
+	target := storage.Target{Backend: backend, Path: path}
+	for k, v := range map[string]*string{
+		"s3-endpoint":   &target.S3.Endpoint,
+		"s3-region":     &target.S3.Region,
+		"s3-bucket":     &target.S3.Bucket,
+		"s3-access-key": &target.S3.AccessKey,
+		"s3-secret-key": &target.S3.SecretKey,
+	} {
+		*v, _ = getPostForm(c, k)
+	}
+	if target.S3.SecretKey == "" {
+		target.S3.SecretKey = config.Cfg.Media.S3.SecretKey
+	}
+	if target.Same(storage.Configured()) {
+		// only the credentials changed
+		previous := config.Cfg.Media
+		config.Cfg.Media.Storage = backend
+		config.Cfg.Media.InDatabase = backend == "database"
+		config.Cfg.Media.Path = path
+		config.Cfg.Media.S3 = target.S3
+		if err := storage.Load(); err != nil {
+			config.Cfg.Media = previous
+			return err
+		}
+	} else {
+		if err := db.StartMigration(target); err != nil {
+			return err
+		}
+		set(c)("info", "The media are being moved to the "+
+			backend+" storage")
 	}
-	config.Cfg.Media.Path = path
 
 	sizeStr, _ := getPostForm(c, "maxsize")
 	size, err := strconv.ParseUint(sizeStr, 10, 64)
@@ -203,6 +235,32 @@ func updateMedia(c echo.Context) error {
 	}
 	config.Cfg.Media.MaxSize = size
 
+	duration, err := getInt(c, "maxduration")
+	if err != nil {
+		return err
+	}
+	if duration < 0 {
+		return errors.New("invalid duration limit")
+	}
+	config.Cfg.Media.MaxDuration = duration
+
+	workers, err := getInt(c, "workers")
+	if err != nil {
+		return err
+	}
+	queueSize, err := getInt(c, "queue-size")
+	if err != nil {
+		return err
+	}
+	if workers < 1 || queueSize < 0 {
+		return errors.New("invalid media processing queue")
+	}
+	requireRestart = requireRestart ||
+		workers != config.Cfg.Media.Workers ||
+		queueSize != config.Cfg.Media.QueueSize
+	config.Cfg.Media.Workers = workers
+	config.Cfg.Media.QueueSize = queueSize
+
 	thresholdStr, _ := getPostForm(c, "threshold")
 	threshold, err := strconv.Atoi(thresholdStr)
 	if err != nil {
@@ -210,31 +268,124 @@ func updateMedia(c echo.Context) error {
 	}
 	config.Cfg.Media.ImageThreshold = threshold
 
-	ntfyURL, _ := getPostForm(c, "ntfy")
-	if ntfyURL != "" {
-		u, err := url.ParseRequestURI(ntfyURL)
-		if err != nil {
+	difference, err := getInt(c, "difference-threshold")
+	if err != nil {
+		return err
+	}
+	perception, err := getInt(c, "perception-threshold")
+	if err != nil {
+		return err
+	}
+	if difference < 0 || difference > 64 ||
+		perception < 0 || perception > 64 {
+		return errors.New("invalid hash threshold")
+	}
+	config.Cfg.Media.DifferenceThreshold = difference
+	config.Cfg.Media.PerceptionThreshold = perception
+
+	matchAll, _ := getPostForm(c, "match-all")
+	config.Cfg.Media.MatchAllHashes = matchAll == "on"
+
+	interval, err := getInt(c, "frame-interval")
+	if err != nil {
+		return err
+	}
+	frames, err := getInt(c, "max-frames")
+	if err != nil {
+		return err
+	}
+	if interval < 0 || frames < 0 {
+		return errors.New("invalid frame sampling")
+	}
+	config.Cfg.Media.FrameInterval = interval
+	config.Cfg.Media.MaxFrames = frames
+
+	thumbnailSize, err := getInt(c, "thumbnail-size")
+	if err != nil {
+		return err
+	}
+	replySize, err := getInt(c, "reply-thumbnail-size")
+	if err != nil {
+		return err
+	}
+	if thumbnailSize < 16 || thumbnailSize > 1000 ||
+		replySize < 16 || replySize > 1000 {
+		return errors.New("invalid thumbnail size")
+	}
+	config.Cfg.Media.ThumbnailSize = thumbnailSize
+	config.Cfg.Media.ReplyThumbnailSize = replySize
+
+	format, _ := getPostForm(c, "thumbnail-format")
+	if err := media.CheckThumbnailFormat(format); err != nil {
+		return err
+	}
+	config.Cfg.Media.ThumbnailFormat = format
+
+	quality, err := getInt(c, "thumbnail-quality")
+	if err != nil {
+		return err
+	}
+	if quality < 1 || quality > 100 {
+		return errors.New("invalid thumbnail quality")
+	}
+	config.Cfg.Media.ThumbnailQuality = quality
+
+	animated, _ := getPostForm(c, "animated-thumbnails")
+	v = animated == "on"
+	if v && !config.Cfg.Media.AnimatedThumbnails {
+		if err := hasFFmpeg(); err != nil {
 			return err
 		}
-		ntfyURL = u.String()
 	}
-	config.Cfg.Media.NotificationURL = ntfyURL
+	config.Cfg.Media.AnimatedThumbnails = v
+
+	animatedDuration, err := getInt(c, "animated-duration")
+	if err != nil {
+		return err
+	}
+	if animatedDuration < 1 {
+		return errors.New("invalid animated thumbnail duration")
+	}
+	config.Cfg.Media.AnimatedDuration = animatedDuration
 
 	video, _ := getPostForm(c, "video")
 	v = video == "on"
 	if v && !config.Cfg.Media.AllowVideos {
-		c := exec.Command("ffmpeg", "-version")
-		if err := c.Run(); err != nil {
+		if err := hasFFmpeg(); err != nil {
 			return err
 		}
 	}
 	config.Cfg.Media.AllowVideos = v
 
-	hotlink, _ := getPostForm(c, "hotlink-shield")
-	config.Cfg.Media.HotlinkShield, err = strconv.Atoi(hotlink)
+	audio, _ := getPostForm(c, "audio")
+	v = audio == "on"
+	if v && !config.Cfg.Media.AllowAudio {
+		if err := hasFFmpeg(); err != nil {
+			return err
+		}
+	}
+	config.Cfg.Media.AllowAudio = v
+
+	keepFilename, _ := getPostForm(c, "keep-filename")
+	config.Cfg.Media.KeepFilename = keepFilename == "on"
+
+	signedURLs, _ := getPostForm(c, "signed-urls")
+	config.Cfg.Media.SignedURLs = signedURLs == "on"
+	lifetime, err := getInt(c, "url-lifetime")
 	if err != nil {
 		return err
 	}
+	if lifetime < 1 {
+		return errors.New("invalid media URL lifetime")
+	}
+	config.Cfg.Media.URLLifetime = lifetime
+	bind, _ := getPostForm(c, "url-session")
+	config.Cfg.Media.URLSession = bind == "on"
+	referrers, _ := getPostForm(c, "allowed-referrers")
+	config.Cfg.Media.AllowedReferrers = strings.Fields(
+		strings.ToLower(referrers))
+	keys, _ := getPostForm(c, "consumer-keys")
+	config.Cfg.Media.ConsumerKeys = strings.Fields(keys)
 
 	data, mime, err := handleImage(c, "pending")
 	if err == nil {
@@ -328,10 +479,19 @@ func createBoard(board, name, description string, self uint) error {
 
 var updateBoard = generic(setBoard, "id", "board", "name", "description",
 		"owner", "enabled", "country-flag", "poster-id",
-		"read-only", "private")
+		"read-only", "private", "captcha", "drop-audio")
 
 func setBoard(id uint, board, name, description, owner string, enabled,
-		countryFlag, posterID, readOnly, private bool) error {
+		countryFlag, posterID, readOnly, private bool, captcha string,
+		dropAudio bool) error {
+	if _, ok := captchaProviders[captcha]; !ok && captcha != "" {
+		return errors.New("invalid captcha provider")
+	}
+	if captcha == "question" {
+		if err := hasCaptchaQuestions(); err != nil {
+			return err
+		}
+	}
 	boards, err := db.GetBoards()
 	if err != nil {
 		return err
@@ -348,6 +508,8 @@ func setBoard(id uint, board, name, description, owner string, enabled,
 		v.PosterID = posterID
 		v.ReadOnly = readOnly
 		v.Private = private
+		v.Captcha = captcha
+		v.DropAudio = dropAudio
 		if owner != "" {
 			account, err := db.GetAccount(owner)
 			if err != nil {
@@ -522,6 +684,15 @@ func proxyAcme(c echo.Context) error {
 	return c.Blob(resp.StatusCode, resp.Header.Get("Content-Type"), data)
 }
 
+func hasFFmpeg() error {
+	for _, command := range []string{"ffmpeg", "ffprobe"} {
+		if err := exec.Command(command, "-version").Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func getInt(c echo.Context, param string) (int, error) {
 	str, ok := getPostForm(c, param)
 	if !ok {
@@ -537,6 +708,29 @@ func getInt(c echo.Context, param string) (int, error) {
 	return v, nil
 }
 
+func updateFlood(c echo.Context) error {
+	var err error
+	tmp := config.Cfg.Flood
+	tmp.Window, err = getInt(c, "window")
+	if err != nil {
+		return err
+	}
+	tmp.MinLength, err = getInt(c, "min-length")
+	if err != nil {
+		return err
+	}
+	siteWide, _ := getPostForm(c, "site-wide")
+	tmp.SiteWide = siteWide == "on"
+	config.Cfg.Flood = tmp
+	return db.UpdateConfig()
+}
+
+func clearFlood(c echo.Context) error {
+	key, _ := getPostForm(c, "key")
+	ratelimit.Flood.Clear(key)
+	return nil
+}
+
 func rateLimits(c echo.Context) error {
 	var err error
 	tmp := config.Cfg.RateLimit
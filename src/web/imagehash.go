@@ -1,24 +1,105 @@
 package web
 
 import (
+	"bytes"
 	"github.com/labstack/echo/v4"
+	"mime"
+	"net/http"
 	"strconv"
 
 	"IB1/db"
+	"IB1/media"
 )
 
+func optionalHash(c echo.Context, param string) (*int64, error) {
+	v := c.FormValue(param)
+	if v == "" {
+		return nil, nil
+	}
+	hash, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &hash, nil
+}
+
 func addBannedHash(c echo.Context) error {
-	hash, err := strconv.Atoi(c.FormValue("hash"))
+	hash, err := strconv.ParseInt(c.FormValue("hash"), 10, 64)
 	if err != nil {
 		return err
 	}
-	return db.AddBannedImage(int64(hash))
+	difference, err := optionalHash(c, "difference")
+	if err != nil {
+		return err
+	}
+	perception, err := optionalHash(c, "perception")
+	if err != nil {
+		return err
+	}
+	return db.AddBannedImage(hash, difference, perception,
+		c.FormValue("note"))
 }
 
 func removeBannedHash(c echo.Context) error {
-	hash, err := strconv.Atoi(c.FormValue("hash"))
+	id, err := strconv.Atoi(c.FormValue("id"))
+	if err != nil {
+		return err
+	}
+	return db.RemoveBannedImage(id)
+}
+
+// importBannedHashes bans the hashes of an uploaded list, the stored media
+// matching the new bans being counted in the background
+func importBannedHashes(c echo.Context) error {
+	file, err := c.FormFile("list")
+	if err != nil {
+		return err
+	}
+	f, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	list, err := db.ParseHashList(f)
+	if err != nil {
+		return err
+	}
+	imported, duplicates, err := db.ImportBannedImages(list,
+		c.FormValue("source"))
+	if err != nil {
+		return err
+	}
+	info := strconv.Itoa(len(imported)) + " bans imported, " +
+		strconv.Itoa(duplicates) + " duplicates skipped"
+	if len(imported) > 0 {
+		if err := media.StartCountMatches(imported); err != nil {
+			info += ", the existing media were not checked: " +
+				err.Error()
+		} else {
+			info += ", checking the existing media"
+		}
+	}
+	set(c)("info", info)
+	return nil
+}
+
+func exportBannedHashes(c echo.Context) error {
+	format := c.QueryParam("format")
+	kind := "application/json"
+	if format != "json" {
+		format, kind = "csv", "text/csv"
+	}
+	name := "banned-hashes"
+	if source := c.QueryParam("source"); source != "" {
+		name += "-" + source
+	}
+	var b bytes.Buffer
+	err := db.ExportBannedImages(&b, format, c.QueryParam("source"))
 	if err != nil {
 		return err
 	}
-	return db.RemoveBannedImage(int64(hash))
+	c.Response().Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment",
+			map[string]string{"filename": name + "." + format}))
+	return c.Blob(http.StatusOK, kind, b.Bytes())
 }
@@ -0,0 +1,120 @@
+package web
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"IB1/db"
+	"IB1/media"
+)
+
+const mediaPerPage = 50
+
+type mediaLibrary struct {
+	Filter db.MediaFilter
+	Medias []db.MediaEntry
+	Count  int64
+	Page   int
+	Pages  int
+	// previous and next pages, 0 when there is none
+	Previous int
+	Next     int
+	// query string of the filter, used by the page links
+	Query string
+	Error string
+}
+
+func getMediaLibrary(c echo.Context) mediaLibrary {
+	if c.Param("page") != "library" {
+		return mediaLibrary{}
+	}
+	library := mediaLibrary{
+		Filter: db.MediaFilter{
+			Type:    c.QueryParam("type"),
+			State:   c.QueryParam("state"),
+			Spoiler: c.QueryParam("spoiler") == "on",
+			Board:   c.QueryParam("board"),
+			Hash:    strings.TrimSpace(c.QueryParam("hash")),
+		},
+	}
+	query := url.Values{}
+	for k, v := range map[string]string{
+		"type": library.Filter.Type, "state": library.Filter.State,
+		"board": library.Filter.Board, "hash": library.Filter.Hash,
+	} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	if library.Filter.Spoiler {
+		query.Set("spoiler", "on")
+	}
+	library.Query = query.Encode()
+
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	library.Medias, library.Count, err = db.GetMedias(library.Filter,
+		page-1, mediaPerPage)
+	if err != nil {
+		library.Error = err.Error()
+	}
+	library.Page = page
+	library.Pages = int((library.Count + mediaPerPage - 1) / mediaPerPage)
+	if page > 1 {
+		library.Previous = page - 1
+	}
+	if page < library.Pages {
+		library.Next = page + 1
+	}
+	return library
+}
+
+var mediaActions = map[string]func(hash string, user db.Account) error{
+	"approve": func(hash string, user db.Account) error {
+		return db.Approve(hash, user.Name)
+	},
+	"spoiler": func(hash string, _ db.Account) error {
+		return db.SetSpoiler(hash, true)
+	},
+	"unspoiler": func(hash string, _ db.Account) error {
+		return db.SetSpoiler(hash, false)
+	},
+	"remove": func(hash string, _ db.Account) error {
+		return db.RemoveMedia(hash)
+	},
+	"ban": func(hash string, _ db.Account) error {
+		if err := media.Ban(hash); err != nil {
+			return err
+		}
+		return db.RemoveMedia(hash)
+	},
+}
+
+func updateMediaLibrary(c echo.Context) error {
+	user, err := loggedAs(c)
+	if err != nil {
+		return err
+	}
+	action, _ := getPostForm(c, "action")
+	f, ok := mediaActions[action]
+	if !ok {
+		return errInvalidForm
+	}
+	hashes := c.Request().PostForm["hash"]
+	if len(hashes) == 0 {
+		return errors.New("no media selected")
+	}
+	for _, hash := range hashes {
+		if err := f(hash, user); err != nil {
+			return err
+		}
+	}
+	return db.AddModLog(user, 0, "media "+action,
+		strconv.Itoa(len(hashes))+" media from the media library")
+}
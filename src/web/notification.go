@@ -0,0 +1,125 @@
+package web
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"IB1/config"
+	"IB1/db"
+	"IB1/notify"
+)
+
+func channelFromForm(c echo.Context) (db.NotificationChannel, error) {
+	channel := db.NotificationChannel{}
+	channel.Name, _ = getPostForm(c, "name")
+	channel.Kind, _ = getPostForm(c, "kind")
+	channel.URL, _ = getPostForm(c, "url")
+	channel.Token, _ = getPostForm(c, "token")
+	channel.Target, _ = getPostForm(c, "target")
+	channel.TitleTemplate, _ = getPostForm(c, "title")
+	channel.MessageTemplate, _ = getPostForm(c, "message")
+	enabled, _ := getPostForm(c, "enabled")
+	channel.Disabled = enabled != "on"
+	channel.Name = strings.TrimSpace(channel.Name)
+	channel.URL = strings.TrimSpace(channel.URL)
+	if channel.Name == "" || channel.URL == "" {
+		return channel, errInvalidForm
+	}
+	channel.Events = []db.NotificationEvent{}
+	for _, v := range db.GetNotificationEvents() {
+		if on, _ := getPostForm(c, string(v)); on == "on" {
+			channel.Events = append(channel.Events, v)
+		}
+	}
+	boards, _ := getPostForm(c, "boards")
+	ids, err := db.GetBoardIDs(boards)
+	if err != nil {
+		return channel, err
+	}
+	channel.Boards = ids
+	return channel, notify.Validate(channel)
+}
+
+// keepToken keeps the token of an existing channel when none is given, the
+// token not being shown on the dashboard
+func keepToken(c echo.Context, channel *db.NotificationChannel) error {
+	if channel.Token != "" || c.Param("id") == "" {
+		return nil
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	previous, err := db.GetChannel(id)
+	if err != nil {
+		return err
+	}
+	channel.Token = previous.Token
+	return nil
+}
+
+func createChannel(c echo.Context) error {
+	channel, err := channelFromForm(c)
+	if err != nil {
+		return err
+	}
+	return db.NotificationChannel{}.Add(channel)
+}
+
+func updateChannel(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	channel, err := channelFromForm(c)
+	if err != nil {
+		return err
+	}
+	if err := keepToken(c, &channel); err != nil {
+		return err
+	}
+	return db.NotificationChannel{}.Update(id, channel)
+}
+
+func deleteChannel(id int) error {
+	return db.NotificationChannel{}.RemoveID(id, db.NotificationChannel{})
+}
+
+// testChannel sends a test notification with the settings of the form,
+// without saving them
+func testChannel(c echo.Context) error {
+	channel, err := channelFromForm(c)
+	if err != nil {
+		return err
+	}
+	if err := keepToken(c, &channel); err != nil {
+		return err
+	}
+	if err := notify.Test(channel); err != nil {
+		return errors.New("test notification failed: " + err.Error())
+	}
+	set(c)("info", "Test notification sent to "+channel.Name)
+	return nil
+}
+
+func updateNotify(c echo.Context) error {
+	var err error
+	tmp := config.Cfg.Notify
+	fields := map[string]*int{
+		"retries":        &tmp.Retries,
+		"login-failures": &tmp.LoginFailures,
+		"login-window":   &tmp.LoginWindow,
+		"expiry-days":    &tmp.ExpiryDays,
+	}
+	for name, v := range fields {
+		*v, err = getInt(c, name)
+		if err != nil {
+			return err
+		}
+	}
+	config.Cfg.Notify = tmp
+	return db.UpdateConfig()
+}
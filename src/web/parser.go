@@ -160,6 +160,7 @@ func minifyHTML(w io.Writer) io.WriteCloser {
 }
 
 var stylesheet []byte = nil
+var stylesheetFingerprint string
 
 func minifyStylesheet() error {
 	m := minify.New()
@@ -173,5 +174,6 @@ func minifyStylesheet() error {
 		return err
 	}
 	stylesheet = res
+	stylesheetFingerprint = fingerprint(res)
 	return nil
 }
@@ -2,6 +2,7 @@ package web
 
 import (
 	"errors"
+	"net/http"
 	"strconv"
 
 	"github.com/labstack/echo/v4"
@@ -119,7 +120,13 @@ func thread(c echo.Context) error {
 	}
 	thread, err = db.GetThread(board, id)
 	if err != nil {
-		return err
+		redirect, rerr := db.GetRedirect(board, id)
+		if rerr != nil {
+			return err
+		}
+		return c.Redirect(http.StatusMovedPermanently, "/"+
+			redirect.ToBoard.Name+"/"+
+			strconv.Itoa(redirect.ToNumber))
 	}
 	if thread.Posts[0].Disabled {
 		if _, err := loggedAs(c); err != nil {
@@ -128,3 +135,11 @@ func thread(c echo.Context) error {
 	}
 	return render("thread.html", thread, c)
 }
+
+func moderation(c echo.Context) error {
+	posts, err := db.GetPendingPosts()
+	if err != nil {
+		return err
+	}
+	return render("moderation.html", posts, c)
+}
@@ -157,6 +157,13 @@ func has(c echo.Context) func(string) bool {
 	}
 }
 
+// notifySession shows a message to a visitor on the next thread they open
+func notifySession(session string, message string) {
+	sessions.Set(db.GetSessionKey(session, "new-post-error"), db.KeyValue{
+		Creation: time.Now(), Key: "new-post-error", Value: message,
+	})
+}
+
 func loggedAs(c echo.Context) (db.Account, error) {
 	token := getCookie(c, "token")
 	if token == "" {
@@ -0,0 +1,117 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"IB1/config"
+)
+
+// signature returns the signature of a media URL valid until expiry, bound
+// to the session if it is not empty
+func signature(path string, expiry int64, session string) string {
+	h := hmac.New(sha256.New, config.Cfg.Media.URLKey)
+	h.Write([]byte(path + "\n" + strconv.FormatInt(expiry, 10) + "\n" +
+		session))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16])
+}
+
+// urlSession returns the session a media URL is bound to
+func urlSession(c echo.Context) string {
+	if !config.Cfg.Media.URLSession {
+		return ""
+	}
+	id, err := getID(c)
+	if err != nil {
+		return ""
+	}
+	return id
+}
+
+// signURL returns the URL of a media or of a banner signed for the visitor,
+// the expiry being rounded so the URL stays the same for a while and can be
+// cached
+func signURL(c echo.Context, path string) string {
+	if !config.Cfg.Media.SignedURLs {
+		return path
+	}
+	lifetime := int64(max(config.Cfg.Media.URLLifetime, 1) * 60)
+	expiry := (time.Now().Unix()/lifetime + 2) * lifetime
+	return path + "?e=" + strconv.FormatInt(expiry, 10) + "&s=" +
+		signature(path, expiry, urlSession(c))
+}
+
+// allowedReferrer reports whether the media are requested from a page of
+// a domain allowed to embed them
+func allowedReferrer(c echo.Context) bool {
+	u, err := url.Parse(c.Request().Referer())
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, v := range config.Cfg.Media.AllowedReferrers {
+		if host == v || strings.HasSuffix(host, "."+v) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedConsumer reports whether the media are requested with the key of
+// a consumer, such as a feed reader, allowed to fetch them directly
+func allowedConsumer(c echo.Context) bool {
+	key := c.QueryParam("key")
+	if key == "" {
+		return false
+	}
+	for _, v := range config.Cfg.Media.ConsumerKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(v)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func validSignature(c echo.Context) bool {
+	expiry, err := strconv.ParseInt(c.QueryParam("e"), 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return false
+	}
+	expected := signature(c.Request().URL.Path, expiry, urlSession(c))
+	return hmac.Equal([]byte(c.QueryParam("s")), []byte(expected))
+}
+
+// signedURL rejects the requests of media and banners whose URL is not
+// signed, unless they come from an allowed referrer or consumer
+func signedURL(f echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !config.Cfg.Media.SignedURLs {
+			return f(c)
+		}
+		for _, v := range c.ParamNames() {
+			if v == "secret" {
+				return f(c)
+			}
+		}
+		uri := c.Request().URL.Path
+		if !strings.HasPrefix(uri, "/media/") &&
+			!strings.HasPrefix(uri, "/banner/") {
+			return f(c)
+		}
+		if validSignature(c) || allowedReferrer(c) ||
+			allowedConsumer(c) {
+			return f(c)
+		}
+		noStore(c)
+		return c.Blob(http.StatusForbidden, "image/png", mediaError)
+	}
+}
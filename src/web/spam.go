@@ -0,0 +1,105 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"IB1/config"
+	"IB1/db"
+	"IB1/filter"
+)
+
+const pendingMessage = "your post is awaiting moderation"
+
+func learnHam(content string) {
+	if err := filter.LearnHam(content); err != nil {
+		log.Println(err)
+	}
+}
+
+func pendingPost(c echo.Context) (db.Post, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return db.Post{}, err
+	}
+	return db.GetPendingPost(uint(id))
+}
+
+func approvePost(c echo.Context) error {
+	post, err := pendingPost(c)
+	if err != nil {
+		return err
+	}
+	if err := db.ApprovePost(post.ID); err != nil {
+		return err
+	}
+	learnHam(string(post.Content))
+	return nil
+}
+
+func denyPost(c echo.Context) error {
+	post, err := pendingPost(c)
+	if err != nil {
+		return err
+	}
+	if err := filter.LearnSpam(post); err != nil {
+		return err
+	}
+	return db.Remove(post.Board.Name, post.Number)
+}
+
+func getFloat(c echo.Context, param string) (float64, error) {
+	str, _ := getPostForm(c, param)
+	v, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 {
+		return 0, errors.New("invalid value")
+	}
+	return v, nil
+}
+
+func updateSpam(c echo.Context) error {
+	var err error
+	tmp := config.Cfg.Spam
+
+	enabled, _ := getPostForm(c, "enabled")
+	tmp.Enabled = enabled == "on"
+
+	floats := map[string]*float64{
+		"hide":        &tmp.Hide,
+		"queue":       &tmp.Queue,
+		"reject":      &tmp.Reject,
+		"link-score":  &tmp.LinkScore,
+		"repeat":      &tmp.RepeatScore,
+		"caps-ratio":  &tmp.CapsRatio,
+		"caps":        &tmp.CapsScore,
+		"domain":      &tmp.DomainScore,
+		"new-session": &tmp.NewSessionScore,
+		"bayes":       &tmp.BayesScore,
+	}
+	for param, v := range floats {
+		*v, err = getFloat(c, param)
+		if err != nil {
+			return err
+		}
+	}
+	tmp.MaxLinks, err = getInt(c, "max-links")
+	if err != nil {
+		return err
+	}
+
+	domains, _ := getPostForm(c, "domains")
+	tmp.BadDomains = []string{}
+	for _, v := range strings.Fields(strings.ToLower(domains)) {
+		tmp.BadDomains = append(tmp.BadDomains, v)
+	}
+
+	config.Cfg.Spam = tmp
+	return db.UpdateConfig()
+}
@@ -9,15 +9,16 @@ import (
 	"html/template"
 	"math/big"
 	"net/http"
-	"strconv"
 	"strings"
 
-	"github.com/gabriel-vasile/mimetype"
 	"github.com/labstack/echo/v4"
 
 	"IB1/config"
 	"IB1/db"
 	"IB1/media"
+	"IB1/notify"
+	"IB1/ratelimit"
+	"IB1/storage"
 	"IB1/util"
 )
 
@@ -72,6 +73,10 @@ func renderContent(_template string, data any, c echo.Context, content string, p
 			return templates.Lookup(template).Execute(w, v)
 		},
 		"session":  func() string { return getCookie(c, "id") },
+		"captcha": func(kind string) string {
+			return getCaptchaProvider(c, kind)
+		},
+		"captchaQuestion": func() string { return captchaQuestion(c) },
 		"isLogged": func() bool { return isLogged(c) },
 		"can": func(priv string) bool {
 			acc, err := loggedAs(c)
@@ -94,6 +99,14 @@ func renderContent(_template string, data any, c echo.Context, content string, p
 			return acc.CanAsMember(board,
 				db.GetMemberPrivilege(priv)) == nil
 		},
+		"canApprove": func() bool {
+			acc, err := loggedAs(c)
+			if err != nil {
+				return false
+			}
+			_, err = acc.ApprovalBoards()
+			return err == nil
+		},
 		"isSelf": func(acc db.Account) bool {
 			self, err := loggedAs(c)
 			if err != nil {
@@ -111,11 +124,8 @@ func renderContent(_template string, data any, c echo.Context, content string, p
 		"canView": func(board db.Board) bool {
 			return canView(c, board) == nil
 		},
-		"hotlink": func() string {
-			if config.Cfg.Media.HotlinkShield == 0 {
-				return ""
-			}
-			return "?v=" + strconv.Itoa(hotlinkHash(c, 0))
+		"signURL": func(path string) string {
+			return signURL(c, path)
 		},
 	}
 	if !plain {
@@ -148,13 +158,13 @@ func initTemplate() error {
 			}
 			return boards
 		},
-		"isCaptchaEnabled": func() bool {
-			return config.Cfg.Captcha.Enabled
-		},
+		"captcha":         func(string) string { return "" },
+		"captchaQuestion": func() string { return "" },
 		"config":    func() config.Config { return config.Cfg },
 		"isLogged":  func() bool { return false },
 		"can":       func(string) bool { return false },
 		"memberCan": func(string) bool { return false },
+		"canApprove": func() bool { return false },
 		"set":       func(string, string) string { return "" },
 		"get":       func(string) string { return "" },
 		"once":      func(string) string { return "" },
@@ -163,7 +173,7 @@ func initTemplate() error {
 		"render":    func(string, any) error { return nil },
 		"session":   func() string { return "" },
 		"csrf":      func() string { return "" },
-		"hotlink":   func() string { return "" },
+		"signURL":   func(path string) string { return path },
 		"hasRank":   func(string) bool { return false },
 		"isSelf":    func(db.Account) bool { return false },
 		"self":      func() db.Account { return db.Account{} },
@@ -173,31 +183,38 @@ func initTemplate() error {
 			}
 			return *i
 		},
+		"captchaLanguages": func() []string {
+			return captchaLanguages
+		},
+		"captchaProviders": func() []string {
+			return captchaProviderNames
+		},
+		"storageBackends":    storage.Backends,
+		"storageBackend":     storage.Current,
+		"migration":          db.GetMigration,
+		"matchCheck":         media.GetMatchCheck,
+		"notificationKinds":  notify.Kinds,
+		"notificationEvents": db.GetNotificationEvents,
+		"newChannel": func() db.NotificationChannel {
+			return db.NotificationChannel{}
+		},
+		"wordfilterActions": func() []db.WordfilterAction {
+			return []db.WordfilterAction{
+				db.WORDFILTER_REPLACE, db.WORDFILTER_REJECT,
+				db.WORDFILTER_PENDING, db.WORDFILTER_BAN,
+			}
+		},
 		"capitalize": func(s string) string {
 			if s == "" {
 				return ""
 			}
 			return strings.ToUpper(s[0:1]) + s[1:]
 		},
-		"pendingMedia": func() string {
-			hash, mime, err := db.GetPendingApproval()
-			if err != nil {
-				return ""
-			}
-			m := mimetype.Lookup(mime)
-			if m == nil {
-				return ""
-			}
-			return hash + m.Extension()
-		},
 		"isPending": func(media string) bool {
 			v, err := db.GetMedia(media)
 			return config.Cfg.Media.ApprovalQueue &&
 				err == nil && !v.Approved
 		},
-		"hasUnapproved": func() bool {
-			return db.HasUnapproved()
-		},
 		"thumbnail": func(media string) string {
 			return strings.Split(media, ".")[0] + ".png"
 		},
@@ -207,6 +224,29 @@ func initTemplate() error {
 		"isVideo": func(name string) bool {
 			return media.IsMedia(name, db.MEDIA_VIDEO)
 		},
+		"isAudio": func(name string) bool {
+			return media.IsMedia(name, db.MEDIA_AUDIO)
+		},
+		"mediaDetails": func(hash string) string {
+			v, err := db.GetMedia(hash)
+			if err != nil {
+				return ""
+			}
+			details := []string{}
+			if v.Size > 0 {
+				details = append(details, fileSize(v.Size))
+			}
+			if v.Width > 0 && v.Height > 0 {
+				details = append(details,
+					fmt.Sprintf("%dx%d", v.Width, v.Height))
+			}
+			if v.Duration > 0 {
+				details = append(details, duration(v.Duration))
+			}
+			return strings.Join(details, ", ")
+		},
+		"fileSize": fileSize,
+		"asset":    asset,
 		"extension": func(path string) string {
 			parts := strings.Split(path, ".")
 			if len(parts) < 1 {
@@ -322,6 +362,25 @@ func renderBoards(c echo.Context) error {
 	return nil
 }
 
+func fileSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	if size < 1024*1024 {
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	}
+	return fmt.Sprintf("%.1f MB", float64(size)/1024/1024)
+}
+
+func duration(v float64) string {
+	seconds := int(v + 0.5)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600,
+			seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
 func renderDashboard(c echo.Context) error {
 	boards, err := db.GetBoards()
 	if err != nil {
@@ -351,6 +410,10 @@ func renderDashboard(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	bannedSources, err := db.GetBannedSources()
+	if err != nil {
+		return err
+	}
 	bans, err := db.GetBanList()
 	if err != nil {
 		return err
@@ -359,6 +422,18 @@ func renderDashboard(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	logs, err := db.GetModLogs(200)
+	if err != nil {
+		return err
+	}
+	questions, err := db.CaptchaQuestion{}.GetAll()
+	if err != nil {
+		return err
+	}
+	channels, err := db.NotificationChannel{}.GetAll()
+	if err != nil {
+		return err
+	}
 	data := struct {
 		Accounts         []db.Account
 		Boards           []db.Board
@@ -369,9 +444,16 @@ func renderDashboard(c echo.Context) error {
 		MemberPrivileges []string
 		Bans             []db.Ban
 		BannedImages     []db.BannedImage
+		BannedSources    []db.BannedSource
 		UserThemes       []db.Theme
 		Wordfilters      []db.Wordfilter
 		Blacklists       []db.Blacklist
+		Logs             []db.ModLog
+		CaptchaQuestions []db.CaptchaQuestion
+		Channels         []db.NotificationChannel
+		Console          wordfilterConsole
+		Library          mediaLibrary
+		Floods           []ratelimit.Fingerprint
 		Ranks            []db.Rank
 		MemberRanks      []db.MemberRank
 		Header           any
@@ -381,10 +463,17 @@ func renderDashboard(c echo.Context) error {
 		Config:           config.Cfg,
 		Bans:             bans,
 		BannedImages:     bannedImages,
+		BannedSources:    bannedSources,
 		Themes:           getThemes(),
 		UserThemes:       themes,
 		Wordfilters:      wordfilters,
 		Blacklists:       blacklists,
+		Logs:             logs,
+		CaptchaQuestions: questions,
+		Channels:         channels,
+		Console:          testWordfilters(c),
+		Library:          getMediaLibrary(c),
+		Floods:           ratelimit.Flood.Tripped(),
 		Ranks:            ranks,
 		MemberRanks:      memberRanks,
 		Privileges:       db.GetPrivileges(),
@@ -12,6 +12,10 @@ import (
 var themes []string
 var themesTable map[string]bool
 var themesContent map[string][]byte
+
+// themesFingerprint holds the fingerprint of the content of every theme,
+// computed once when they are loaded
+var themesFingerprint map[string]string
 var legacyBrowsers = []string{
 	"Dillo",
 	"NetSurf",
@@ -28,6 +32,7 @@ func getThemes() []string {
 	}
 	themes = []string{}
 	themesContent = map[string][]byte{}
+	themesFingerprint = map[string]string{}
 	for _, v := range files {
 		if !v.Type().IsRegular() || v.Name() == "common.css" {
 			continue
@@ -47,6 +52,7 @@ func getThemes() []string {
 			continue
 		}
 		themesContent[v.Name()] = data
+		themesFingerprint[v.Name()] = fingerprint(data)
 		themes = append(themes, theme)
 	}
 	dbThemes, err := db.Theme{}.GetAll()
@@ -58,6 +64,8 @@ func getThemes() []string {
 			themes = append(themes, v.Name)
 			themesTable[v.Name] = true
 			themesContent[v.Name+".css"] = []byte(v.Content)
+			themesFingerprint[v.Name+".css"] = fingerprint(
+				[]byte(v.Content))
 		}
 	}
 	return themes
@@ -2,16 +2,19 @@ package web
 
 import (
 	"errors"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 
 	"IB1/config"
 	"IB1/db"
+	"IB1/filter"
 	"IB1/media"
+	"IB1/notify"
 	"IB1/ratelimit"
-	"IB1/filter"
 )
 
 func readOnly(f echo.HandlerFunc) echo.HandlerFunc {
@@ -26,7 +29,7 @@ func readOnly(f echo.HandlerFunc) echo.HandlerFunc {
 func loginAs(c echo.Context) error {
 	name := c.Request().PostFormValue("username")
 	password := c.Request().PostFormValue("password")
-	err := verifyCaptcha(c)
+	err := checkCaptcha(c, "login")
 	if err != nil {
 		return err
 	}
@@ -38,6 +41,7 @@ func loginAs(c echo.Context) error {
 	}
 	token, err := db.Login(name, password)
 	if err != nil {
+		notify.LoginFailed()
 		return err
 	}
 	setCookie(c, "token", token)
@@ -57,7 +61,7 @@ func register(c echo.Context) error {
 	if confirm != password {
 		return errors.New("passwords don't match")
 	}
-	err := verifyCaptcha(c)
+	err := checkCaptcha(c, "registration")
 	if err != nil {
 		return err
 	}
@@ -90,6 +94,12 @@ func disconnect(c echo.Context) error {
 }
 
 func onPost(f func(db.Post) error) echo.HandlerFunc {
+	return onPostContext(func(post db.Post, _ echo.Context) error {
+		return f(post)
+	})
+}
+
+func onPostContext(f func(db.Post, echo.Context) error) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		board := c.Param("board")
 		id, err := strconv.Atoi(c.Param("id"))
@@ -105,7 +115,7 @@ func onPost(f func(db.Post) error) echo.HandlerFunc {
 		if err != nil {
 			return err
 		}
-		if err := f(post); err != nil {
+		if err := f(post, c); err != nil {
 			return err
 		}
 
@@ -119,6 +129,9 @@ func onPost(f func(db.Post) error) echo.HandlerFunc {
 }
 
 func remove(post db.Post) error {
+	if err := filter.LearnSpam(post); err != nil {
+		return err
+	}
 	return db.Remove(post.Board.Name, post.Number)
 }
 
@@ -126,8 +139,19 @@ func removeMedia(post db.Post) error {
 	return db.RemoveMedia(post.MediaHash)
 }
 
-func approveMediaFromPost(post db.Post) error {
-	return db.Approve(post.MediaHash)
+func approveMediaFromPost(post db.Post, c echo.Context) error {
+	user, err := loggedAs(c)
+	if err != nil {
+		return err
+	}
+	if err := user.CanApprove(post.MediaHash); err != nil {
+		return err
+	}
+	if err := db.Approve(post.MediaHash, user.Name); err != nil {
+		return err
+	}
+	return db.AddModLog(user, post.Board.ID, "media approve",
+		"media of post "+strconv.Itoa(post.Number))
 }
 
 func hide(post db.Post) error {
@@ -146,6 +170,228 @@ func pin(post db.Post) error {
 	return thread.Pin()
 }
 
+func moveThread(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	from, err := db.GetBoard(c.Param("board"))
+	if err != nil {
+		return err
+	}
+	name, _ := getPostForm(c, "board")
+	to, err := db.GetBoard(name)
+	if err != nil {
+		return err
+	}
+	thread, err := db.GetThread(from, id)
+	if err != nil {
+		return err
+	}
+	number, err := db.MoveThread(thread, to)
+	if err != nil {
+		return err
+	}
+	return c.Redirect(http.StatusFound,
+		"/"+to.Name+"/"+strconv.Itoa(number))
+}
+
+func mergeThread(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	board, err := db.GetBoard(c.Param("board"))
+	if err != nil {
+		return err
+	}
+	src, err := db.GetThread(board, id)
+	if err != nil {
+		return err
+	}
+	name, _ := getPostForm(c, "board")
+	if board, err = db.GetBoard(name); err != nil {
+		return err
+	}
+	number, _ := getPostForm(c, "thread")
+	id, err = strconv.Atoi(number)
+	if err != nil {
+		return errors.New("invalid thread number")
+	}
+	dst, err := db.GetThread(board, id)
+	if err != nil {
+		return err
+	}
+	if err := db.MergeThreads(src, dst); err != nil {
+		return err
+	}
+	return c.Redirect(http.StatusFound,
+		"/"+dst.Board.Name+"/"+strconv.Itoa(dst.Number))
+}
+
+const banAnnotation = "(USER WAS BANNED FOR THIS POST)"
+
+// banDuration returns the duration in seconds chosen in a form, a day by
+// default
+func banDuration(c echo.Context) int64 {
+	duration, _ := getPostForm(c, "duration")
+	seconds, err := strconv.ParseInt(duration, 10, 64)
+	if err != nil || seconds < 1 {
+		return 86400
+	}
+	return seconds
+}
+
+func annotate(post db.Post, c echo.Context) error {
+	annotation, _ := getPostForm(c, "annotation")
+	banned, _ := getPostForm(c, "ban")
+	hidden, _ := getPostForm(c, "hide")
+	user, err := loggedAs(c)
+	if err != nil {
+		return err
+	}
+	if banned == "on" {
+		err := user.CanAsMember(post.Board, db.BAN_USER.Member())
+		if err != nil {
+			return err
+		}
+		err = db.BanIP(post.IP, banDuration(c), post.Board.ID)
+		if err != nil {
+			return err
+		}
+		if annotation == "" {
+			annotation = banAnnotation
+		}
+	}
+	if hidden == "on" && !post.Disabled {
+		if err := db.Hide(post.ID, false); err != nil {
+			return err
+		}
+	}
+	return db.Annotate(post.ID, strings.TrimSpace(annotation))
+}
+
+func bulkPosts(c echo.Context, by string, scope string) (
+	db.Post, []db.Post, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return db.Post{}, nil, err
+	}
+	post, err := db.GetPostFromBoard(c.Param("board"), id)
+	if err != nil {
+		return db.Post{}, nil, err
+	}
+	post.Board, err = db.GetBoard(c.Param("board"))
+	if err != nil {
+		return db.Post{}, nil, err
+	}
+	if scope == "site" {
+		if err := needPrivilege(c, db.REMOVE_POST); err != nil {
+			return db.Post{}, nil, err
+		}
+	}
+	posts, err := db.GetPostsBy(post, by, scope == "site")
+	return post, posts, err
+}
+
+func bulkPreview(c echo.Context) error {
+	by := c.QueryParam("by")
+	scope := c.QueryParam("scope")
+	post, posts, err := bulkPosts(c, by, scope)
+	if err != nil {
+		return err
+	}
+	threads := 0
+	for _, v := range posts {
+		if v.Number == v.Thread.Number {
+			threads++
+		}
+	}
+	return render("bulk.html", struct {
+		Post    db.Post
+		Posts   []db.Post
+		Threads int
+		By      string
+		Scope   string
+	}{post, posts, threads, by, scope}, c)
+}
+
+func bulkDelete(c echo.Context) error {
+	by, _ := getPostForm(c, "by")
+	scope, _ := getPostForm(c, "scope")
+	banned, _ := getPostForm(c, "ban")
+	user, err := loggedAs(c)
+	if err != nil {
+		return err
+	}
+	post, posts, err := bulkPosts(c, by, scope)
+	if err != nil {
+		return err
+	}
+	boardID := uint(post.BoardID)
+	if scope == "site" {
+		boardID = 0
+	}
+	if banned == "on" {
+		err := user.CanAsMember(post.Board, db.BAN_USER.Member())
+		if err != nil {
+			return err
+		}
+		if boardID == 0 {
+			if err := needPrivilege(c, db.BAN_USER); err != nil {
+				return err
+			}
+		}
+	}
+	ips := map[string]bool{}
+	removed := map[int]bool{}
+	threads := 0
+	for _, v := range posts {
+		if v.Number != v.Thread.Number {
+			continue
+		}
+		if err := filter.LearnSpam(v); err != nil {
+			return err
+		}
+		if err := db.Remove(v.Board.Name, v.Number); err != nil {
+			return err
+		}
+		ips[v.IP] = true
+		removed[v.ThreadID] = true
+		threads++
+	}
+	for _, v := range posts {
+		if removed[v.ThreadID] {
+			continue
+		}
+		if err := filter.LearnSpam(v); err != nil {
+			return err
+		}
+		if err := db.Remove(v.Board.Name, v.Number); err != nil {
+			return err
+		}
+		ips[v.IP] = true
+	}
+	details := "by " + by + " of /" + post.Board.Name + "/" +
+		strconv.Itoa(post.Number) + ": " + strconv.Itoa(len(posts)) +
+		" posts, " + strconv.Itoa(threads) + " threads"
+	if banned == "on" {
+		seconds := banDuration(c)
+		for ip := range ips {
+			if err := db.BanIP(ip, seconds, boardID); err != nil {
+				return err
+			}
+		}
+		details += ", " + strconv.Itoa(len(ips)) + " ips banned for " +
+			strconv.FormatInt(seconds, 10) + " seconds"
+	}
+	if err := db.AddModLog(user, boardID, "bulk delete",
+		details); err != nil {
+		return err
+	}
+	return c.Redirect(http.StatusFound, "/"+post.Board.Name)
+}
+
 func banMedia(post db.Post) error {
 	return media.Ban(post.MediaHash)
 }
@@ -195,6 +441,50 @@ func ban(c echo.Context) error {
 	return nil
 }
 
+var errForbiddenWords = errors.New("your post contains forbidden words")
+
+func filterPost(c echo.Context, board db.Board,
+	texts ...*string) (db.PostState, error) {
+	result, err := filter.FilterPost(board, texts...)
+	if err != nil {
+		return db.POST_VISIBLE, err
+	}
+	if result.Ban > 0 {
+		err := db.BanIP(clientIP(c), result.Ban, result.BanBoard)
+		if err != nil {
+			return db.POST_VISIBLE, err
+		}
+	}
+	if result.Reject {
+		return db.POST_VISIBLE, errForbiddenWords
+	}
+	if result.Pending {
+		return db.POST_PENDING, nil
+	}
+	return db.POST_VISIBLE, nil
+}
+
+// processMedia queues the upload of a post, the poster is told through
+// their session if the media cannot be processed
+func processMedia(upload *media.Upload, board db.Board, number int, id uint,
+	session string, approved bool, spoiler bool) {
+	media.Process(upload, board, approved, spoiler,
+		func(name string, err error) {
+			if err == nil {
+				err = db.AttachMedia(id, name)
+				if err == nil {
+					return
+				}
+			}
+			log.Println("media processing failed:", err)
+			if err := db.DetachMedia(id); err != nil {
+				log.Println(err)
+			}
+			notifySession(session, "The media of post "+
+				strconv.Itoa(number)+" was removed: "+err.Error())
+		})
+}
+
 func newThread(c echo.Context) error {
 
 	if err := isBanned(c); err != nil {
@@ -217,36 +507,73 @@ func newThread(c echo.Context) error {
 		return errors.New("invalid form")
 	}
 
-	if err := checkCaptcha(c); err != nil {
+	if err := checkCaptcha(c, "thread"); err != nil {
 		return err
 	}
 	if err := ratelimit.Thread.Try(clientIP(c)); err != nil {
 		return err
 	}
 
-	mediaFile := ""
 	file, err := c.FormFile("media")
 	if err != nil {
 		return err
 	}
+	filtered, err := filterPost(c, board,
+		&content, &name, &title, &file.Filename)
+	if err != nil {
+		return err
+	}
+	state, err := filter.Check(filter.Candidate{
+		Title: title, Name: name, Content: content,
+		Session: getCookie(c, "id"), IP: clientIP(c),
+	})
+	if err != nil {
+		return err
+	}
+	state = max(state, filtered)
+
 	user, err := loggedAs(c)
 	if err == nil && signed == "on" {
 		name = user.Name
 	}
-	approved := user.Can(db.BYPASS_MEDIA_APPROVAL) == nil
-	mediaFile, err = media.UploadFile(file, approved, spoiler == "on")
+	upload, err := media.Prepare(file)
 	if err != nil {
 		return err
 	}
+	filename := ""
+	if config.Cfg.Media.KeepFilename {
+		filename = media.SanitizeFilename(file.Filename,
+			upload.Extension)
+	}
+
+	err = ratelimit.Flood.Check(board.Name, title+content, upload.Hash)
+	if err != nil {
+		upload.Discard()
+		return err
+	}
 
 	parsed, _ := parseContent(content, 0)
-	number, err := db.CreateThread(board, title, name, mediaFile, clientIP(c),
-		getCookie(c, "id"), user,
-		signed == "on", rank == "on", parsed)
+	number, id, err := db.CreateThread(board, title, name, "", filename, true,
+		clientIP(c), getCookie(c, "id"), user,
+		signed == "on", rank == "on", parsed, state)
 	if err != nil {
+		ratelimit.Flood.Release(board.Name, title+content, upload.Hash)
+		upload.Discard()
 		return err
 	}
+	processMedia(upload, board, number, id, getCookie(c, "id"),
+		user.Can(db.BYPASS_MEDIA_APPROVAL) == nil, spoiler == "on")
 
+	switch state {
+	case db.POST_PENDING:
+		set(c)("new-thread-error", pendingMessage)
+		fallthrough
+	case db.POST_HIDDEN:
+		c.Redirect(http.StatusFound, c.Request().URL.Path)
+		return nil
+	}
+	notify.NewThread(board.Name, number, title)
+	learnHam(title + "\n" + content)
 	c.Redirect(http.StatusFound, c.Request().URL.Path+"/"+
 		strconv.Itoa(number))
 	return nil
@@ -285,44 +612,86 @@ func newPost(c echo.Context) error {
 	spoiler, _ := getPostForm(c, "spoiler")
 	sage, _ := getPostForm(c, "sage")
 
-	if err := checkCaptcha(c); err != nil {
+	if err := checkCaptcha(c, "post"); err != nil {
 		return err
 	}
 	if err := ratelimit.Post.Try(clientIP(c)); err != nil {
 		return err
 	}
 
-	mediaFile := ""
+	texts := []*string{&content, &name}
+	file, fileErr := c.FormFile("media")
+	if fileErr == nil {
+		texts = append(texts, &file.Filename)
+	}
+	filtered, err := filterPost(c, board, texts...)
+	if err != nil {
+		return err
+	}
+	state, err := filter.Check(filter.Candidate{
+		Name: name, Content: content,
+		Session: getCookie(c, "id"), IP: clientIP(c),
+	})
+	if err != nil {
+		return err
+	}
+	state = max(state, filtered)
+
+	var upload *media.Upload
+	hash := ""
+	filename := ""
 	user, err := loggedAs(c)
 	if err == nil && signed == "on" {
 		name = user.Name
 	}
-	file, err := c.FormFile("media")
-	if err == nil {
-		approved := user.Can(db.BYPASS_MEDIA_APPROVAL) == nil
-		mediaFile, err = media.UploadFile(
-				file, approved, spoiler == "on")
+	if fileErr == nil {
+		upload, err = media.Prepare(file)
 		if err != nil {
 			return err
 		}
+		hash = upload.Hash
+		if config.Cfg.Media.KeepFilename {
+			filename = media.SanitizeFilename(
+				file.Filename, upload.Extension)
+		}
+	}
+	discard := func() {
+		if upload != nil {
+			upload.Discard()
+		}
 	}
 
-	content, err = filter.FilterText(content)
+	err = ratelimit.Flood.Check(board.Name, content, hash)
 	if err != nil {
+		discard()
 		return err
 	}
+
 	parsed, refs := parseContent(content, thread.ID)
-	number, err := db.CreatePost(thread, parsed, name, mediaFile,
-		clientIP(c), getCookie(c, "id"), user, signed == "on",
-		rank == "on", sage == "on", nil)
+	number, id, err := db.CreatePost(thread, parsed, name, "", filename,
+		upload != nil, clientIP(c), getCookie(c, "id"), user,
+		signed == "on", rank == "on", sage == "on", state, nil)
 	if err != nil {
+		ratelimit.Flood.Release(board.Name, content, hash)
+		discard()
 		return err
 	}
+	if upload != nil {
+		processMedia(upload, board, number, id, getCookie(c, "id"),
+			user.Can(db.BYPASS_MEDIA_APPROVAL) == nil,
+			spoiler == "on")
+	}
 
 	for _, v := range refs {
 		db.CreateReference(thread.ID, number, v)
 	}
 
+	if state == db.POST_PENDING {
+		set(c)("new-post-error", pendingMessage)
+	} else if state == db.POST_VISIBLE {
+		learnHam(content)
+	}
+
 	c.Redirect(http.StatusFound, c.Request().URL.Path)
 	return nil
 }
@@ -1,17 +1,16 @@
 package web
 
 import (
-	"bytes"
-	"crypto/rand"
-	"encoding/binary"
-	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
 	"hash/fnv"
+	"io"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
 	"strconv"
 	"strings"
 	"syscall"
@@ -21,12 +20,71 @@ import (
 	"IB1/db"
 	"IB1/dnsbl"
 	"IB1/media"
+	"IB1/notify"
 	"IB1/ratelimit"
+	"IB1/storage"
 )
 
-func serveMedia(c echo.Context, data []byte, name string) {
-	r := bytes.NewReader(data)
-	http.ServeContent(c.Response().Writer, c.Request(), name, time.Now(), r)
+func serveStored(c echo.Context, key string, media db.Media) error {
+	r, info, err := storage.NewReader(storage.Media(), key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	header := c.Response().Header()
+	header.Set("ETag", fmt.Sprintf(`"%s-%x"`,
+		strings.ReplaceAll(key, "/", "-"), info.Size))
+	header.Set("Cache-Control", mediaCacheControl(media))
+	http.ServeContent(c.Response().Writer, c.Request(), path.Base(key),
+		info.ModTime, r)
+	return nil
+}
+
+// storedMedia serves a file of the media storage
+func storedMedia(c echo.Context) error {
+	media, err := db.GetMedia(strings.Split(c.Param("hash"), ".")[0])
+	if err != nil {
+		return err
+	}
+	return serveStored(c, media.Key(), media)
+}
+
+// storedThumbnail serves the thumbnail of a media whatever the extension
+// requested, the thumbnail of the replies falling back to the one of the
+// OP for media uploaded before they had their own
+func storedThumbnail(reply bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		media, err := db.GetMedia(strings.Split(c.Param("hash"), ".")[0])
+		if err != nil {
+			return err
+		}
+		key := media.ThumbnailKey()
+		if reply {
+			_, err := storage.Media().Stat(media.ReplyThumbnailKey())
+			if err == nil {
+				key = media.ReplyThumbnailKey()
+			}
+		}
+		// thumbnails keep their URL when they are regenerated, spoilered
+		// or approved, so they are revalidated with the fingerprint of
+		// their content
+		r, err := storage.Media().Get(key)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		cache := mediaCacheControl(media)
+		if cache == immutableCache {
+			cache = "no-cache"
+		}
+		c.Response().Header().Set("Cache-Control", cache)
+		serveMedia(c, data, path.Base(key))
+		return nil
+	}
 }
 
 func clientIP(c echo.Context) string {
@@ -53,6 +111,7 @@ func imageError(f echo.HandlerFunc) echo.HandlerFunc {
 		if err := f(c); err == nil {
 			return nil
 		}
+		noStore(c)
 		serveMedia(c, mediaError, "media")
 		return nil
 	}
@@ -63,10 +122,6 @@ func mediaCheck(f echo.HandlerFunc) echo.HandlerFunc {
 		if !config.Cfg.Media.ApprovalQueue {
 			return f(c)
 		}
-		user, err := loggedAs(c)
-		if err == nil && user.Can(db.VIEW_PENDING_MEDIA) == nil {
-			return f(c)
-		}
 		hash := strings.Split(c.Param("hash"), ".")[0]
 		media, err := db.GetMedia(hash)
 		if err != nil {
@@ -75,6 +130,13 @@ func mediaCheck(f echo.HandlerFunc) echo.HandlerFunc {
 		if media.Approved {
 			return f(c)
 		}
+		// the approvers of a board see the media they are asked to
+		// approve
+		user, err := loggedAs(c)
+		if err == nil && (user.Can(db.VIEW_PENDING_MEDIA) == nil ||
+			user.CanApprove(hash) == nil) {
+			return f(c)
+		}
 		return pendingMediaImage(c)
 	}
 }
@@ -104,46 +166,6 @@ func secretCheck(f echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
-func approveMedia(c echo.Context) error {
-	hash := ""
-	if c.Request().Method == "GET" {
-		hash = c.Param("hash")
-		err := db.ApprovalBypass{}.Delete(c.Param("secret"))
-		if err != nil {
-			return err
-		}
-	} else {
-		hash = c.FormValue("media")
-	}
-	return db.Approve(strings.Split(hash, ".")[0])
-}
-
-func approveAll(c echo.Context) error {
-	return db.ApproveAll()
-}
-
-func denyMedia(c echo.Context) error {
-	hash := ""
-	if c.Request().Method == "GET" {
-		hash = c.Param("hash")
-		db.ApprovalBypass{}.Delete(c.Param("secret"))
-	} else {
-		hash = c.FormValue("media")
-	}
-	return db.RemoveMedia(strings.Split(hash, ".")[0])
-}
-
-func banPendingMedia(c echo.Context) error {
-	hash := strings.Split(c.FormValue("media"), ".")[0]
-	if err := media.Ban(hash); err != nil {
-		return err
-	}
-	if err := db.RemoveMedia(hash); err != nil {
-		return err
-	}
-	return nil
-}
-
 func err(f echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		if err := f(c); err != nil {
@@ -158,8 +180,17 @@ func err(f echo.HandlerFunc) echo.HandlerFunc {
 
 func csp(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		c.Response().Header().Add("Content-Security-Policy",
-			"default-src 'none'; style-src 'self'; img-src 'self'; media-src 'self'; frame-ancestors 'none'; base-uri 'none'; form-action 'self'")
+		policy := "default-src 'none'; style-src 'self'; img-src 'self'; media-src 'self'; frame-ancestors 'none'; base-uri 'none'; form-action 'self'"
+		// the widget of the external captcha provider runs a script in a
+		// frame served by the provider
+		if sources := externalCaptchaSources(); sources != "" {
+			policy = "default-src 'none'; style-src 'self' " + sources +
+				"; img-src 'self'; media-src 'self'; script-src " +
+				sources + "; frame-src " + sources + "; connect-src " +
+				sources + "; frame-ancestors 'none'; base-uri 'none'; " +
+				"form-action 'self'"
+		}
+		c.Response().Header().Add("Content-Security-Policy", policy)
 		c.Response().Header().Add("X-Frame-Options", "Deny")
 		c.Response().Header().Add("X-Content-Type-Options", "nosniff")
 		c.Response().Header().Add("Referrer-Policy", "no-referrer")
@@ -324,53 +355,6 @@ func isBlacklisted(c echo.Context) error {
 	return dnsbl.IsListed(clientIP(c), isReadOnly(c))
 }
 
-func hotlinkHash(c echo.Context, offset int) int {
-	id, err := getID(c)
-	if id == "" || err != nil {
-		v := time.Now().UnixMicro()
-		binary.Read(rand.Reader, binary.BigEndian, &v)
-		return int(v)
-	}
-	v := time.Now().Unix() / 600 + int64(offset)
-	if config.Cfg.Media.HotlinkShield == 1 {
-		return int(v)
-	}
-	h := fnv.New32()
-	h.Write(config.Cfg.Media.HotlinkKey)
-	binary.Write(h, binary.BigEndian, v)
-	if config.Cfg.Media.HotlinkShield == 2 {
-		return int(h.Sum32())
-	}
-	h.Write([]byte(id))
-	return int(h.Sum32())
-}
-
-func hotlinkShield(f echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		if config.Cfg.Media.HotlinkShield == 0 {
-			return f(c)
-		}
-		for _, v := range c.ParamNames() {
-			if v != "secret" {
-				continue
-			}
-			return f(c)
-		}
-		media := strings.HasPrefix(c.Request().RequestURI, "/media/")
-		banner := strings.HasPrefix(c.Request().RequestURI, "/banner/")
-		if !media && !banner {
-			return f(c)
-		}
-		v, err := strconv.Atoi(c.QueryParam("v"))
-		if err == nil {
-			if v == hotlinkHash(c, 0) || v == hotlinkHash(c, -1) {
-				return f(c)
-			}
-		}
-		return errors.New("expired")
-	}
-}
-
 func blacklistCheck(f echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		if err := isBlacklisted(c); err != nil {
@@ -422,6 +406,7 @@ func text(f echo.HandlerFunc, text string) echo.HandlerFunc {
 }
 
 func pendingMediaImage(c echo.Context) error {
+	noStore(c)
 	if config.Cfg.Media.PendingMime == "" {
 		return c.Blob(http.StatusOK, "image/png", pendingMedia)
 	}
@@ -430,6 +415,7 @@ func pendingMediaImage(c echo.Context) error {
 }
 
 func spoilerImage(c echo.Context) error {
+	noStore(c)
 	if config.Cfg.Media.SpoilerMime == "" {
 		return c.Blob(http.StatusOK, "image/png", spoiler)
 	}
@@ -456,10 +442,9 @@ func Init() error {
 		os.Exit(1)
 	}()
 
-	if !config.Cfg.Media.InDatabase {
-		os.MkdirAll(config.Cfg.Media.Path+"/thumbnail", 0700)
-	}
 	os.MkdirAll(config.Cfg.Media.Tmp, 0700)
+	media.StartWorkers()
+	notify.Start()
 
 	r := echo.New()
 	if err := initTemplate(); err != nil {
@@ -470,7 +455,7 @@ func Init() error {
 	r.Use(csp)
 	r.Use(err)
 	r.Use(csrf)
-	r.Use(hotlinkShield)
+	r.Use(signedURL)
 	r.Use(blacklistCheck)
 	r.Use(privateBoard)
 
@@ -489,17 +474,16 @@ func Init() error {
 	r.GET("/static/pending", pendingMediaImage)
 	r.GET("/static/spoiler", spoilerImage)
 	r.GET("/static/common.css", func(c echo.Context) error {
-		return c.Blob(http.StatusOK, "text/css", stylesheet)
+		serveMedia(c, stylesheet, "common.css")
+		return nil
 	})
 	r.GET("/static/:file", func(c echo.Context) error {
+		getThemes()
 		content, ok := themesContent[c.Param("file")]
-		if !ok {
-			return notFound(c)
+		if ok {
+			serveMedia(c, content, c.Param("file"))
+			return nil
 		}
-		serveMedia(c, content, c.Param("file"))
-		return nil
-	})
-	r.GET("/static/:file", func(c echo.Context) error {
 		sub, err := fs.Sub(static, "static")
 		if err != nil {
 			return err
@@ -520,6 +504,7 @@ func Init() error {
 	r.GET("/css/:board/:thread", threadCSS)
 	if config.Cfg.Captcha.Enabled {
 		r.GET("/captcha", captchaImage)
+		r.GET("/captcha/audio", captchaAudio)
 	}
 	r.GET("/:board", boardIndex)
 	r.GET("/:board/catalog", catalog)
@@ -538,25 +523,38 @@ func Init() error {
 		hasBoardPrivilege(onPost(remove), db.REMOVE_POST.Member()))
 	r.GET("/:board/hide/:id/:csrf",
 		hasBoardPrivilege(onPost(hide), db.HIDE_POST.Member()))
+	r.POST("/:board/annotate/:id", hasBoardPrivilege(
+		onPostContext(annotate), db.HIDE_POST.Member()))
+	r.GET("/:board/delete_by/:id",
+		hasBoardPrivilege(bulkPreview, db.REMOVE_POST.Member()))
+	r.POST("/:board/delete_by/:id",
+		hasBoardPrivilege(bulkDelete, db.REMOVE_POST.Member()))
 	r.GET("/:board/spoil/:id/:csrf",
 		hasBoardPrivilege(onPost(spoil), db.TOGGLE_SPOILER.Member()))
 	r.GET("/:board/pin/:id/:csrf",
 		hasBoardPrivilege(onPost(pin), db.PIN_THREAD.Member()))
+	r.POST("/:board/move/:id", hasPrivilege(moveThread, db.MOVE_THREAD))
+	r.POST("/:board/merge/:id", hasPrivilege(mergeThread, db.MOVE_THREAD))
 	r.GET("/:board/remove_media/:id/:csrf", hasBoardPrivilege(
 		onPost(removeMedia), db.REMOVE_MEDIA.Member()))
 	r.GET("/:board/ban_media/:id/:csrf",
 		hasPrivilege(onPost(banMedia), db.BAN_MEDIA))
 	r.GET("/:board/approve/:id/:csrf", hasBoardPrivilege(
-		onPost(approveMediaFromPost), db.APPROVE_MEDIA.Member()))
+		onPostContext(approveMediaFromPost),
+		db.APPROVE_MEDIA.Member()))
 	r.GET("/:board/ban/:ip/:csrf",
 		hasBoardPrivilege(ban, db.BAN_USER.Member()))
+	r.GET("/moderation", hasPrivilege(moderation, db.REMOVE_POST))
+	r.POST("/moderation/approve/:id", hasPrivilege(redirect(
+		approvePost, "/moderation"), db.REMOVE_POST))
+	r.POST("/moderation/deny/:id", hasPrivilege(redirect(
+		denyPost, "/moderation"), db.REMOVE_POST))
 	if config.Cfg.Media.ApprovalQueue {
-		r.GET("/approval", hasPrivilege(
-			renderFile("approval.html"), db.APPROVE_MEDIA))
-		r.POST("/approval/accept", hasPrivilege(redirect(
-			approveMedia, "/approval"), db.APPROVE_MEDIA))
-		r.POST("/approval/deny", hasPrivilege(redirect(
-			denyMedia, "/approval"), db.APPROVE_MEDIA))
+		r.GET("/approval", approver(approval))
+		r.POST("/approval/accept", approver(redirect(
+			approveMedia, "/approval")))
+		r.POST("/approval/deny", approver(redirect(
+			denyMedia, "/approval")))
 		r.POST("/approval/ban", hasPrivilege(redirect(
 			banPendingMedia, "/approval"), db.APPROVE_MEDIA))
 		r.POST("/approval/accept/all", hasPrivilege(redirect(
@@ -599,6 +597,14 @@ func Init() error {
 		handleConfig(addBannedHash, "media"))
 	r.POST("/config/media/ban/cancel",
 		handleConfig(removeBannedHash, "media"))
+	r.POST("/config/media/ban/import",
+		handleConfig(importBannedHashes, "media"))
+	r.POST("/config/media/ban/source/remove", handleConfig(generic(
+		db.RemoveBannedSource, "source"), "media"))
+	r.GET("/config/media/ban/export",
+		hasPrivilege(exportBannedHashes, db.ADMINISTRATION))
+	r.POST("/config/library/update",
+		handleConfig(updateMediaLibrary, "library"))
 	r.POST("/config/ssl/update", handleConfig(updateSSL, "ssl"))
 	r.POST("/config/board/create", handleConfig(createBoardReq, "board"))
 	r.POST("/config/board/update/:id",
@@ -614,11 +620,22 @@ func Init() error {
 		updateTheme, "id", "name", "enabled"), "theme"))
 
 	r.POST("/config/wordfilter/create", handleConfig(generic(
-		createWordfilter, "from", "to", "enabled"), "wordfilter"))
+		createWordfilter, "from", "to", "enabled", "action",
+		"duration", "boards"), "wordfilter"))
 	r.POST("/config/wordfilter/delete/:id", handleConfig(generic(
 		deleteWordfilter, "id"), "wordfilter"))
 	r.POST("/config/wordfilter/update/:id", handleConfig(generic(
-		updateWordfilter, "id", "from", "to", "enabled"), "wordfilter"))
+		updateWordfilter, "id", "from", "to", "enabled", "action",
+		"duration", "boards"), "wordfilter"))
+
+	r.POST("/config/captcha/update",
+		handleConfig(updateCaptcha, "captcha"))
+	r.POST("/config/captcha/question/create", handleConfig(generic(
+		createCaptchaQuestion, "question", "answers"), "captcha"))
+	r.POST("/config/captcha/question/delete/:id", handleConfig(generic(
+		deleteCaptchaQuestion, "id"), "captcha"))
+	r.POST("/config/captcha/question/update/:id", handleConfig(generic(
+		updateCaptchaQuestion, "id", "question", "answers"), "captcha"))
 
 	r.POST("/config/blacklist/create", handleConfig(generic(
 		createBlacklist, "host", "enabled", "allow-read"), "blacklist"))
@@ -667,59 +684,31 @@ func Init() error {
 		db.RemoveBanner, "id"), "banner"))
 	r.POST("/config/ratelimit/update",
 		handleConfig(rateLimits, "rate-limit"))
+	r.POST("/config/spam/update", handleConfig(updateSpam, "spam"))
+	r.POST("/config/notification/update", handleConfig(updateNotify,
+		"notification"))
+	r.POST("/config/notification/create", handleConfig(createChannel,
+		"notification"))
+	r.POST("/config/notification/update/:id", handleConfig(updateChannel,
+		"notification"))
+	r.POST("/config/notification/delete/:id", handleConfig(generic(
+		deleteChannel, "id"), "notification"))
+	r.POST("/config/notification/test", handleConfig(testChannel,
+		"notification"))
+	r.POST("/config/notification/test/:id", handleConfig(testChannel,
+		"notification"))
+	r.POST("/config/flood/update", handleConfig(updateFlood, "flood"))
+	r.POST("/config/flood/clear", handleConfig(clearFlood, "flood"))
 	r.GET("/banner/:id", imageError(banner))
 	r.GET("/.well-known/acme-challenge/:token", proxyAcme)
 
-	if config.Cfg.Media.InDatabase {
-		r.GET("/media/:hash", imageError(mediaCheck(
-			func(c echo.Context) error {
-				parts := strings.Split(c.Param("hash"), ".")
-				data, _, err := db.GetMediaData(parts[0])
-				if err != nil {
-					return err
-				}
-				serveMedia(c, data, c.Param("hash"))
-				return nil
-			})))
-		f := func(c echo.Context) error {
-			parts := strings.Split(c.Param("hash"), ".")
-			data, err := db.GetThumbnail(parts[0])
-			if err != nil {
-				return err
-			}
-			serveMedia(c, data, c.Param("hash"))
-			return nil
-		}
-		r.GET("/media/thumbnail/:hash", imageError(
-			thumbnailCheck(mediaCheck(f))))
-		r.GET("/media/thumbnail/:secret/:hash",
-			imageError(secretCheck(f)))
-	} else if config.Cfg.Media.ApprovalQueue {
-		f := func(c echo.Context) error {
-			path := c.Request().URL.Path
-			path = strings.TrimPrefix(path, "/media")
-			secret := c.Param("secret")
-			if secret != "" {
-				path = strings.Replace(path, secret+"/", "", 1)
-			}
-			path = config.Cfg.Media.Path + path
-			f, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			http.ServeContent(c.Response().Writer,
-				c.Request(), c.Param("hash"),
-				time.Now(), f)
-			return nil
-		}
-		r.GET("/media/:hash", imageError(mediaCheck(f)))
-		r.GET("/media/thumbnail/:hash",
-			imageError(thumbnailCheck(mediaCheck(f))))
-		r.GET("/media/thumbnail/:secret/:hash",
-			imageError(secretCheck(f)))
-	} else {
-		r.Static("/media", config.Cfg.Media.Path)
-	}
+	r.GET("/media/:hash", imageError(mediaCheck(storedMedia)))
+	r.GET("/media/thumbnail/:hash", imageError(
+		thumbnailCheck(mediaCheck(storedThumbnail(false)))))
+	r.GET("/media/thumbnail/reply/:hash", imageError(
+		thumbnailCheck(mediaCheck(storedThumbnail(true)))))
+	r.GET("/media/thumbnail/:secret/:hash",
+		imageError(secretCheck(storedThumbnail(false))))
 
 	if s := os.Getenv("IB1_LISTENER"); s != "" {
 		return r.Start(s)
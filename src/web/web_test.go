@@ -0,0 +1,128 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"IB1/config"
+	"IB1/db"
+	"IB1/storage"
+)
+
+// TestMain runs the tests against a database and a media storage in a
+// temporary directory
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "ib1-web")
+	if err != nil {
+		log.Fatal(err)
+	}
+	db.Path = filepath.Join(dir, "ib1.db")
+	if err := db.Init(); err != nil {
+		log.Fatal(err)
+	}
+	s, err := storage.NewFilesystem(filepath.Join(dir, "media"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	storage.SetMedia(s)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestPendingThumbnail(t *testing.T) {
+	s := storage.Media()
+	approval := config.Cfg.Media.ApprovalQueue
+	config.Cfg.Media.ApprovalQueue = true
+	defer func() { config.Cfg.Media.ApprovalQueue = approval }()
+
+	for _, name := range []string{"owner", "approver", "member"} {
+		if err := db.CreateAccount(name, "password", "User",
+			false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	owner, err := db.GetAccount("owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if err := db.CreateBoard(name, name, "", owner.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.LoadBoards(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Boards["a"].AddMember("approver", "Janitor"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Boards["a"].AddMember("member", "User"); err != nil {
+		t.Fatal(err)
+	}
+
+	session := strings.Repeat("s", 32)
+	thumbnails := map[string]string{}
+	for board, hash := range map[string]string{"a": "pendinga", "b": "pendingb"} {
+		media := db.Media{Hash: hash, Extension: ".png"}
+		thumbnails[hash] = "thumbnail of " + hash
+		_, err := db.AddMedia(media, func() error {
+			return s.Put(media.ThumbnailKey(),
+				strings.NewReader(thumbnails[hash]))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = db.CreateThread(db.Boards[board], "", "", hash+".png",
+			"", false, "127.0.0.1", session, db.Account{}, false, false,
+			"content", db.POST_VISIBLE)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler := imageError(thumbnailCheck(mediaCheck(
+		storedThumbnail(false))))
+	tests := []struct {
+		account string
+		hash    string
+		visible bool
+	}{
+		{"", "pendinga", false},
+		{"member", "pendinga", false},
+		{"approver", "pendinga", true},
+		{"approver", "pendingb", false},
+		{"owner", "pendinga", true},
+		{"owner", "pendingb", true},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/media/thumbnail/"+test.hash+
+			".png", nil)
+		if test.account != "" {
+			token, err := db.Login(test.account, "password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.AddCookie(&http.Cookie{Name: "token", Value: token})
+		}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("hash")
+		c.SetParamValues(test.hash + ".png")
+		if err := handler(c); err != nil {
+			t.Fatal(err)
+		}
+		visible := rec.Body.String() == thumbnails[test.hash]
+		if visible != test.visible {
+			t.Errorf("%q fetching %s: thumbnail served = %v, want %v",
+				test.account, test.hash, visible, test.visible)
+		}
+	}
+}
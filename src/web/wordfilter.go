@@ -1,23 +1,48 @@
 package web
 
 import (
-	"regexp"
+	"errors"
+
+	"github.com/labstack/echo/v4"
 
 	"IB1/db"
 	"IB1/filter"
 )
 
-func createWordfilter(from, to string, enabled bool) error {
-	_, err := regexp.Compile(from)
+func newWordfilter(from, to string, enabled bool, action int,
+	duration int, boards string) (db.Wordfilter, error) {
+	_, err := filter.Compile(from)
+	if err != nil {
+		return db.Wordfilter{}, err
+	}
+	if action < 0 || action > int(db.WORDFILTER_BAN) {
+		return db.Wordfilter{}, errors.New("invalid action")
+	}
+	if duration < 0 {
+		return db.Wordfilter{}, errors.New("invalid ban duration")
+	}
+	ids, err := db.GetBoardIDs(boards)
+	if err != nil {
+		return db.Wordfilter{}, err
+	}
+	return db.Wordfilter{
+		From:        from,
+		To:          to,
+		Disabled:    !enabled,
+		Action:      db.WordfilterAction(action),
+		BanDuration: int64(duration),
+		Boards:      ids,
+	}, nil
+}
+
+func createWordfilter(from, to string, enabled bool, action int,
+	duration int, boards string) error {
+	w, err := newWordfilter(from, to, enabled, action, duration, boards)
 	if err != nil {
 		return err
 	}
 	filter.Wordfilters.Refresh()
-	return db.Wordfilter{}.Add(db.Wordfilter{
-		From:     from,
-		To:       to,
-		Disabled: !enabled,
-	})
+	return db.Wordfilter{}.Add(w)
 }
 
 func deleteWordfilter(id int) error {
@@ -25,15 +50,58 @@ func deleteWordfilter(id int) error {
 	return db.Wordfilter{}.RemoveID(id, db.Wordfilter{})
 }
 
-func updateWordfilter(id int, from, to string, enabled bool) error {
-	_, err := regexp.Compile(from)
+func updateWordfilter(id int, from, to string, enabled bool, action int,
+	duration int, boards string) error {
+	w, err := newWordfilter(from, to, enabled, action, duration, boards)
 	if err != nil {
 		return err
 	}
 	filter.Wordfilters.Refresh()
-	return db.Wordfilter{}.Update(id, db.Wordfilter{
-		From:     from,
-		To:       to,
-		Disabled: !enabled,
-	})
+	return db.Wordfilter{}.Update(id, w)
+}
+
+type wordfilterConsole struct {
+	Sample   string
+	Board    string
+	Matches  []filter.Match
+	Output   string
+	Result   filter.Result
+	Pattern  string
+	Boards   string
+	Affected int
+	Total    int
+	Error    string
+}
+
+func testWordfilters(c echo.Context) wordfilterConsole {
+	if c.Param("page") != "wordfilter" {
+		return wordfilterConsole{}
+	}
+	console := wordfilterConsole{
+		Sample:  c.QueryParam("sample"),
+		Board:   c.QueryParam("board"),
+		Pattern: c.QueryParam("pattern"),
+		Boards:  c.QueryParam("boards"),
+	}
+	var err error
+	if console.Sample != "" {
+		var board *db.Board
+		if v, ok := db.Boards[console.Board]; ok {
+			board = &v
+		}
+		console.Matches, console.Output, console.Result, err =
+			filter.Test(console.Sample, board)
+	}
+	if err == nil && console.Pattern != "" {
+		var ids []uint
+		ids, err = db.GetBoardIDs(console.Boards)
+		if err == nil {
+			console.Affected, console.Total, err =
+				filter.DryRun(console.Pattern, ids)
+		}
+	}
+	if err != nil {
+		console.Error = err.Error()
+	}
+	return console
 }